@@ -15,9 +15,20 @@
 package config
 
 import (
+	"os"
 	"time"
 )
 
+const (
+	// ClientAuthTypeNone specifies that the server does not request client certificates.
+	ClientAuthTypeNone = "none"
+	// ClientAuthTypeRequest specifies that the server requests, but does not require or verify, client certificates.
+	ClientAuthTypeRequest = "request"
+	// ClientAuthTypeRequireAndVerify specifies that the server requires clients to present a certificate that is
+	// verified against the configured client CAs.
+	ClientAuthTypeRequireAndVerify = "require-and-verify"
+)
+
 // Install specifies the base install configuration fields that should be included in all witchcraft-go-server server
 // install configurations.
 type Install struct {
@@ -39,4 +50,66 @@ type Server struct {
 	ClientCAFiles  []string `yaml:"client-ca-files,omitempty"`
 	CertFile       string   `yaml:"cert-file,omitempty"`
 	KeyFile        string   `yaml:"key-file,omitempty"`
+
+	// Listen optionally specifies a "unix://" URI (for example, "unix:///var/run/service.sock") that configures the
+	// server to listen on the Unix domain socket at the provided path rather than on Address and Port. Any stale socket
+	// at the path is removed on startup and the socket is removed when the server stops.
+	Listen string `yaml:"listen,omitempty"`
+
+	// SocketFileMode is the file mode set on the Unix domain socket created when Listen specifies a socket. If unset,
+	// 0660 is used.
+	SocketFileMode os.FileMode `yaml:"socket-file-mode,omitempty"`
+
+	// ClientAuthType specifies the TLS client authentication type of the server: one of "none", "request" or
+	// "require-and-verify". If unset, the client authentication type configured in code is used. Client certificates
+	// are verified against the certificates in ClientCAFiles, which are reloaded when the files change.
+	ClientAuthType string `yaml:"client-auth-type,omitempty"`
+
+	// Timeouts configures the timeouts and header size limit of the underlying HTTP servers. Fields that are unset use
+	// the defaults of http.Server.
+	Timeouts Timeouts `yaml:"timeouts,omitempty"`
+
+	// H2C configures the main server to serve cleartext HTTP/1.1 and HTTP/2 ("h2c") rather than HTTPS. This is intended
+	// for servers that are only reachable through a proxy or service mesh that terminates TLS and speaks HTTP/2 to the
+	// server. The management server always serves HTTPS if it listens on its own port. The server fails to start if
+	// H2C is combined with a client authentication type other than "none" or with ClientCAFiles.
+	H2C bool `yaml:"h2c,omitempty"`
+
+	// HTTP2 configures the HTTP/2 settings advertised by the server for both HTTPS and h2c connections.
+	HTTP2 HTTP2 `yaml:"http2,omitempty"`
+
+	// ShutdownTimeout bounds the graceful shutdown of the server. When the server is shut down in response to a SIGTERM
+	// or SIGINT signal, the on-shutdown hooks and the drain of in-flight requests must complete within ShutdownTimeout,
+	// after which any remaining connections are closed. The hooks registered using WithOnShutdown may run for at most
+	// ShutdownTimeout, including when Server.Shutdown is called directly. When Server.Shutdown is called directly, the
+	// drain of the main server is bounded only by the context provided to Server.Shutdown. The drain of the management
+	// server is bounded by ShutdownTimeout or by the deadline of the context provided to Server.Shutdown, whichever is
+	// earlier. If unset, a default of 15 seconds is used. Must not be negative.
+	ShutdownTimeout time.Duration `yaml:"shutdown-timeout,omitempty"`
+}
+
+// Timeouts specifies the values of the corresponding fields of the http.Server of a server. A zero value leaves the
+// corresponding http.Server field unset (for the timeouts, this means that there is no timeout). Negative values are
+// invalid.
+//
+// Timeouts apply to the connection as a whole rather than to individual routes: in particular, WriteTimeout bounds the
+// total time available to read a request and write its response, so handlers that stream responses or that enforce
+// their own per-route deadlines cannot run for longer than WriteTimeout when it is set.
+type Timeouts struct {
+	ReadHeaderTimeout time.Duration `yaml:"read-header-timeout,omitempty"`
+	ReadTimeout       time.Duration `yaml:"read-timeout,omitempty"`
+	WriteTimeout      time.Duration `yaml:"write-timeout,omitempty"`
+	IdleTimeout       time.Duration `yaml:"idle-timeout,omitempty"`
+	MaxHeaderBytes    int           `yaml:"max-header-bytes,omitempty"`
+}
+
+// HTTP2 specifies the HTTP/2 settings of a server. A zero value uses the default of the golang.org/x/net/http2 package.
+// Negative values are invalid.
+type HTTP2 struct {
+	// MaxConcurrentStreams is the maximum number of concurrent streams that each client may have open.
+	MaxConcurrentStreams uint32 `yaml:"max-concurrent-streams,omitempty"`
+	// InitialStreamWindowSize is the initial flow-control window size, in bytes, of each stream.
+	InitialStreamWindowSize int32 `yaml:"initial-stream-window-size,omitempty"`
+	// InitialConnWindowSize is the initial flow-control window size, in bytes, of each connection.
+	InitialConnWindowSize int32 `yaml:"initial-connection-window-size,omitempty"`
 }
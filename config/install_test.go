@@ -39,6 +39,19 @@ server:
     - b
   cert-file: certFile
   key-file: keyFile
+  listen: unix:///var/run/example.sock
+  socket-file-mode: 0600
+  client-auth-type: require-and-verify
+  timeouts:
+    read-header-timeout: 10s
+    idle-timeout: 2m
+    max-header-bytes: 65536
+  h2c: true
+  http2:
+    max-concurrent-streams: 100
+    initial-stream-window-size: 1048576
+    initial-connection-window-size: 2097152
+  shutdown-timeout: 30s
 `
 	var install Install
 	err := yaml.Unmarshal([]byte(conf), &install)
@@ -46,13 +59,28 @@ server:
 	assert.Equal(t, Install{
 		ProductName: "productName",
 		Server: Server{
-			Address:        "address",
-			Port:           10,
-			ManagementPort: 11,
-			ContextPath:    "ContextPath",
-			ClientCAFiles:  []string{"a", "b"},
-			CertFile:       "certFile",
-			KeyFile:        "keyFile",
+			Address:         "address",
+			Port:            10,
+			ManagementPort:  11,
+			ContextPath:     "ContextPath",
+			ClientCAFiles:   []string{"a", "b"},
+			CertFile:        "certFile",
+			KeyFile:         "keyFile",
+			Listen:          "unix:///var/run/example.sock",
+			SocketFileMode:  0600,
+			ClientAuthType:  ClientAuthTypeRequireAndVerify,
+			ShutdownTimeout: 30 * time.Second,
+			Timeouts: Timeouts{
+				ReadHeaderTimeout: 10 * time.Second,
+				IdleTimeout:       2 * time.Minute,
+				MaxHeaderBytes:    65536,
+			},
+			H2C: true,
+			HTTP2: HTTP2{
+				MaxConcurrentStreams:    100,
+				InitialStreamWindowSize: 1048576,
+				InitialConnWindowSize:   2097152,
+			},
 		},
 		MetricsEmitFrequency:      time.Second,
 		TraceSampleRate:           asFloat(0.5),
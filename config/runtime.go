@@ -32,6 +32,9 @@ type Runtime struct {
 
 type DiagnosticsConfig struct {
 	DebugSharedSecret string `yaml:"debug-shared-secret"`
+	// GoroutineDumpOnPanic configures whether a dump of all goroutines is written to the diagnostic log when the server
+	// recovers a panic in a request handler.
+	GoroutineDumpOnPanic bool `yaml:"goroutine-dump-on-panic,omitempty"`
 }
 
 type HealthChecksConfig struct {
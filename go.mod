@@ -1,6 +1,6 @@
 module github.com/palantir/witchcraft-go-server/v2
 
-go 1.16
+go 1.18
 
 require (
 	github.com/gorilla/mux v1.7.3
@@ -22,6 +22,29 @@ require (
 	github.com/palantir/witchcraft-go-params v1.2.0
 	github.com/palantir/witchcraft-go-tracing v1.4.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/net v0.23.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.3.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/openzipkin/zipkin-go v0.2.2 // indirect
+	github.com/palantir/pkg v1.0.1 // indirect
+	github.com/palantir/pkg/bytesbuffers v1.0.1 // indirect
+	github.com/palantir/pkg/datetime v1.0.1 // indirect
+	github.com/palantir/pkg/retry v1.1.1 // indirect
+	github.com/palantir/pkg/safelong v1.0.1 // indirect
+	github.com/palantir/pkg/safeyaml v1.0.1 // indirect
+	github.com/palantir/pkg/transform v1.0.0 // indirect
+	github.com/palantir/pkg/uuid v1.0.1 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/rs/zerolog v1.20.0 // indirect
+	github.com/satori/go.uuid v1.2.0 // indirect
+	go.uber.org/atomic v1.6.0 // indirect
+	go.uber.org/multierr v1.5.0 // indirect
+	go.uber.org/zap v1.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
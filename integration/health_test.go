@@ -34,6 +34,7 @@ import (
 	"github.com/palantir/witchcraft-go-server/v2/config"
 	"github.com/palantir/witchcraft-go-server/v2/status"
 	"github.com/palantir/witchcraft-go-server/v2/witchcraft"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft/readiness"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -560,3 +561,55 @@ func (cwt healthCheckWithType) HealthStatus(_ context.Context) health.HealthStat
 func stringPtr(s string) *string {
 	return &s
 }
+
+// TestReadinessGates verifies that the server is not reported as ready while readiness gates registered by the init
+// function are pending, that the readiness payload lists the pending gates and that the server becomes ready once all
+// gates are open.
+func TestReadinessGates(t *testing.T) {
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	managementPort, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	var cacheGate, indexGate *readiness.Gate
+	server, serverErr, cleanup := createAndRunCustomTestServer(t, port, managementPort, func(ctx context.Context, info witchcraft.InitInfo) (deferFn func(), rErr error) {
+		cacheGate = info.ReadinessGates.NewGate("cache-warmup")
+		indexGate = info.ReadinessGates.NewGate("index-warmup")
+		return nil, nil
+	}, ioutil.Discard, createTestServer)
+	defer func() {
+		require.NoError(t, server.Close())
+	}()
+	defer cleanup()
+
+	getReadiness := func() (int, readiness.Status) {
+		resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d%s/%s", managementPort, basePath, status.ReadinessEndpoint))
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var readinessStatus readiness.Status
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&readinessStatus))
+		return resp.StatusCode, readinessStatus
+	}
+
+	statusCode, readinessStatus := getReadiness()
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Equal(t, []string{"cache-warmup", "index-warmup"}, readinessStatus.PendingGates)
+
+	cacheGate.Open()
+	statusCode, readinessStatus = getReadiness()
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Equal(t, []string{"index-warmup"}, readinessStatus.PendingGates)
+
+	indexGate.Open()
+	statusCode, readinessStatus = getReadiness()
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Empty(t, readinessStatus.PendingGates)
+
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	default:
+	}
+}
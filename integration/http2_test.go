@@ -0,0 +1,362 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/palantir/pkg/httpserver"
+	"github.com/palantir/witchcraft-go-server/v2/config"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// TestServerH2C verifies that a server configured with h2c serves cleartext HTTP/2 requests, that responses can be
+// streamed to the client and that the request logging and panic recovery middleware apply to HTTP/2 streams.
+func TestServerH2C(t *testing.T) {
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	releaseStream := make(chan struct{})
+	logOutputBuffer := &bytes.Buffer{}
+	initFn := func(ctx context.Context, info witchcraft.InitInfo) (func(), error) {
+		if err := info.Router.Get("/stream", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = fmt.Fprintln(rw, "first")
+			rw.(http.Flusher).Flush()
+			<-releaseStream
+			_, _ = fmt.Fprintln(rw, "second")
+		})); err != nil {
+			return nil, err
+		}
+		return nil, info.Router.Get("/panic", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			panic("h2c handler panic")
+		}))
+	}
+	server := createTestServer(t, initFn, config.Install{
+		ProductName:   productName,
+		UseConsoleLog: true,
+		Server: config.Server{
+			Address:     "localhost",
+			Port:        port,
+			ContextPath: basePath,
+			H2C:         true,
+			HTTP2: config.HTTP2{
+				MaxConcurrentStreams: 10,
+			},
+		},
+	}, logOutputBuffer)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	ready := <-httpserver.Ready(func() (*http.Response, error) {
+		return client.Get(fmt.Sprintf("http://localhost:%d%s/ok", port, basePath))
+	}, httpserver.WaitTimeoutParam(5*time.Second))
+	require.True(t, ready, "timed out waiting for server to start")
+
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%d%s/stream", port, basePath))
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the first line must be received while the handler is still blocked
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", line)
+	close(releaseStream)
+	remaining, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(remaining))
+
+	panicResp, err := client.Get(fmt.Sprintf("http://localhost:%d%s/panic", port, basePath))
+	require.NoError(t, err)
+	_ = panicResp.Body.Close()
+	assert.Equal(t, 2, panicResp.ProtoMajor)
+	assert.Equal(t, http.StatusInternalServerError, panicResp.StatusCode)
+
+	require.NoError(t, server.Close())
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+
+	var streamLogged bool
+	for _, entry := range getLogMessagesOfType(t, "request.2", logOutputBuffer.Bytes()) {
+		if entry["path"] == basePath+"/stream" {
+			streamLogged = true
+			assert.Equal(t, "HTTP/2.0", entry["protocol"])
+			assert.Equal(t, float64(http.StatusOK), entry["status"])
+			assert.NotEmpty(t, entry["traceId"])
+		}
+	}
+	assert.True(t, streamLogged, "streaming request was not recorded in the request log")
+}
+
+// TestServerHTTP2Settings verifies that a server configured with HTTP/2 settings continues to serve HTTP/2 over TLS.
+func TestServerHTTP2Settings(t *testing.T) {
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	server := createTestServer(t, nil, config.Install{
+		ProductName:   productName,
+		UseConsoleLog: true,
+		Server: config.Server{
+			Address:     "localhost",
+			Port:        port,
+			ContextPath: basePath,
+			HTTP2: config.HTTP2{
+				MaxConcurrentStreams:    10,
+				InitialStreamWindowSize: 1 << 20,
+				InitialConnWindowSize:   1 << 21,
+			},
+		},
+	}, ioutil.Discard)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+	require.True(t, <-waitForTestServerReady(port, "example/ok", 5*time.Second), "timed out waiting for server to start")
+
+	client := &http.Client{Transport: &http2.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get(fmt.Sprintf("https://localhost:%d%s/ok", port, basePath))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	default:
+	}
+}
+
+// TestServerH2CShutdown verifies that a graceful shutdown of a server configured with h2c waits for in-flight HTTP/2
+// streams to complete and that closing the server closes connections with in-flight streams.
+func TestServerH2CShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		graceful bool
+	}{
+		{name: "shutdown waits for in-flight stream", graceful: true},
+		{name: "close terminates in-flight stream", graceful: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			port, err := httpserver.AvailablePort()
+			require.NoError(t, err)
+
+			streamStarted := make(chan struct{})
+			releaseStream := make(chan struct{})
+			defer close(releaseStream)
+			initFn := func(ctx context.Context, info witchcraft.InitInfo) (func(), error) {
+				return nil, info.Router.Get("/slow", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					close(streamStarted)
+					select {
+					case <-releaseStream:
+					case <-req.Context().Done():
+						return
+					}
+					_, _ = fmt.Fprint(rw, "done")
+				}))
+			}
+			server := createTestServer(t, initFn, config.Install{
+				ProductName:   productName,
+				UseConsoleLog: true,
+				Server: config.Server{
+					Address:     "localhost",
+					Port:        port,
+					ContextPath: basePath,
+					H2C:         true,
+				},
+			}, ioutil.Discard)
+			serverErr := make(chan error, 1)
+			go func() {
+				serverErr <- server.Start()
+			}()
+			defer func() {
+				_ = server.Close()
+			}()
+
+			client := &http.Client{Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				},
+			}}
+			ready := <-httpserver.Ready(func() (*http.Response, error) {
+				return client.Get(fmt.Sprintf("http://localhost:%d%s/ok", port, basePath))
+			}, httpserver.WaitTimeoutParam(5*time.Second))
+			require.True(t, ready, "timed out waiting for server to start")
+
+			type result struct {
+				body string
+				err  error
+			}
+			streamResult := make(chan result, 1)
+			go func() {
+				resp, err := client.Get(fmt.Sprintf("http://localhost:%d%s/slow", port, basePath))
+				if err != nil {
+					streamResult <- result{err: err}
+					return
+				}
+				defer func() {
+					_ = resp.Body.Close()
+				}()
+				body, err := ioutil.ReadAll(resp.Body)
+				streamResult <- result{body: string(body), err: err}
+			}()
+			select {
+			case <-streamStarted:
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "timed out waiting for stream to start")
+			}
+
+			if !tc.graceful {
+				require.NoError(t, server.Close())
+				select {
+				case res := <-streamResult:
+					assert.Error(t, res.err, "in-flight stream completed successfully after server was closed")
+				case <-time.After(5 * time.Second):
+					require.Fail(t, "in-flight stream was not terminated when server was closed")
+				}
+				return
+			}
+
+			shutdownErr := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				shutdownErr <- server.Shutdown(ctx)
+			}()
+			select {
+			case err := <-shutdownErr:
+				require.Fail(t, "shutdown returned before in-flight stream completed", "error: %v", err)
+			case err := <-serverErr:
+				require.Fail(t, "server stopped before in-flight stream completed", "error: %v", err)
+			case <-time.After(500 * time.Millisecond):
+			}
+
+			releaseStream <- struct{}{}
+			select {
+			case res := <-streamResult:
+				require.NoError(t, res.err)
+				assert.Equal(t, "done", res.body)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "timed out waiting for in-flight stream to complete")
+			}
+			select {
+			case err := <-shutdownErr:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "timed out waiting for shutdown to complete")
+			}
+			select {
+			case err := <-serverErr:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "timed out waiting for server to stop")
+			}
+		})
+	}
+}
+
+// TestServerH2CRejectsClientAuth verifies that a server configured with h2c fails to start if it is also configured to
+// authenticate clients using certificates, which requires TLS.
+func TestServerH2CRejectsClientAuth(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		configure func(serverCfg *config.Server, server *witchcraft.Server)
+		wantErr   string
+	}{
+		{
+			name: "client-auth-type",
+			configure: func(serverCfg *config.Server, server *witchcraft.Server) {
+				serverCfg.ClientAuthType = config.ClientAuthTypeRequireAndVerify
+			},
+			wantErr: "server h2c cannot be combined with client certificate authentication",
+		},
+		{
+			name: "WithClientAuth",
+			configure: func(serverCfg *config.Server, server *witchcraft.Server) {
+				server.WithClientAuth(tls.RequestClientCert)
+			},
+			wantErr: "server h2c cannot be combined with client certificate authentication",
+		},
+		{
+			name: "client-ca-files",
+			configure: func(serverCfg *config.Server, server *witchcraft.Server) {
+				serverCfg.ClientCAFiles = []string{"testdata/ca-cert.pem"}
+			},
+			wantErr: "server h2c cannot be combined with client-ca-files",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			port, err := httpserver.AvailablePort()
+			require.NoError(t, err)
+			serverCfg := config.Server{
+				Address:     "localhost",
+				Port:        port,
+				ContextPath: basePath,
+				H2C:         true,
+			}
+			server := witchcraft.NewServer()
+			tc.configure(&serverCfg, server)
+			server = server.
+				WithInstallConfig(config.Install{
+					ProductName:   productName,
+					UseConsoleLog: true,
+					Server:        serverCfg,
+				}).
+				WithRuntimeConfig(config.Runtime{}).
+				WithECVKeyProvider(witchcraft.ECVKeyNoOp()).
+				WithDisableGoRuntimeMetrics().
+				WithLoggerStdoutWriter(ioutil.Discard)
+			err = server.Start()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
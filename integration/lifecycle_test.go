@@ -0,0 +1,230 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/palantir/pkg/httpserver"
+	"github.com/palantir/witchcraft-go-server/v2/config"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServerLifecycleHooks verifies that on-started hooks run in registration order with the bound addresses of the
+// main and management servers and that on-shutdown hooks run in registration order before the init cleanup function.
+func TestServerLifecycleHooks(t *testing.T) {
+	var eventsMutex sync.Mutex
+	var events []string
+	recordEvent := func(event string) {
+		eventsMutex.Lock()
+		defer eventsMutex.Unlock()
+		events = append(events, event)
+	}
+	getEvents := func() []string {
+		eventsMutex.Lock()
+		defer eventsMutex.Unlock()
+		return append([]string(nil), events...)
+	}
+
+	startInfoC := make(chan witchcraft.ServerStartInfo, 1)
+	initFn := func(ctx context.Context, info witchcraft.InitInfo) (func(), error) {
+		return func() {
+			recordEvent("cleanup")
+		}, nil
+	}
+	createServer := func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+		return createTestServer(t, initFn, installCfg, logOutputBuffer).
+			WithOnStarted(func(ctx context.Context, info witchcraft.ServerStartInfo) error {
+				recordEvent("started 1")
+				startInfoC <- info
+				return nil
+			}).
+			WithOnStarted(func(ctx context.Context, info witchcraft.ServerStartInfo) error {
+				recordEvent("started 2")
+				return nil
+			}).
+			WithOnShutdown(func(ctx context.Context) error {
+				recordEvent("shutdown 1")
+				return fmt.Errorf("failing shutdown hook")
+			}).
+			WithOnShutdown(func(ctx context.Context) error {
+				recordEvent("shutdown 2")
+				return nil
+			})
+	}
+
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	managementPort, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	server, serverErr, cleanup := createAndRunCustomTestServer(t, port, managementPort, initFn, ioutil.Discard, createServer)
+	defer func() {
+		_ = server.Close()
+	}()
+	defer cleanup()
+
+	select {
+	case info := <-startInfoC:
+		assert.Equal(t, port, info.Address.(*net.TCPAddr).Port)
+		assert.Equal(t, managementPort, info.ManagementAddress.(*net.TCPAddr).Port)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for on-started hook")
+	}
+
+	require.NoError(t, server.Shutdown(context.Background()))
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+	assert.Equal(t, []string{"started 1", "started 2", "shutdown 1", "shutdown 2", "cleanup"}, getEvents())
+}
+
+// TestServerOnStartedHookError verifies that an error returned by an on-started hook stops the server, prevents later
+// hooks from running, runs the on-shutdown hooks so that earlier on-started hooks can be undone and is returned by Start.
+func TestServerOnStartedHookError(t *testing.T) {
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	var secondHookCalled, shutdownHookCalled bool
+	server := createTestServer(t, nil, config.Install{
+		ProductName:   productName,
+		UseConsoleLog: true,
+		Server: config.Server{
+			Address:     "localhost",
+			Port:        port,
+			ContextPath: basePath,
+		},
+	}, ioutil.Discard).
+		WithOnStarted(func(ctx context.Context, info witchcraft.ServerStartInfo) error {
+			return fmt.Errorf("service discovery unavailable")
+		}).
+		WithOnStarted(func(ctx context.Context, info witchcraft.ServerStartInfo) error {
+			secondHookCalled = true
+			return nil
+		}).
+		WithOnShutdown(func(ctx context.Context) error {
+			shutdownHookCalled = true
+			return nil
+		})
+	defer func() {
+		_ = server.Close()
+	}()
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- server.Start()
+	}()
+	select {
+	case err := <-errC:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "service discovery unavailable")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+	assert.False(t, secondHookCalled)
+	assert.True(t, shutdownHookCalled)
+	assert.False(t, server.Running())
+}
+
+// TestServerOnStartedHooksSkippedAfterStop verifies that on-started hooks are not run once the server has stopped
+// serving.
+func TestServerOnStartedHooksSkippedAfterStop(t *testing.T) {
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	var server *witchcraft.Server
+	var secondHookCalled bool
+	server = createTestServer(t, nil, config.Install{
+		ProductName:   productName,
+		UseConsoleLog: true,
+		Server: config.Server{
+			Address:     "localhost",
+			Port:        port,
+			ContextPath: basePath,
+		},
+	}, ioutil.Discard).
+		WithOnStarted(func(ctx context.Context, info witchcraft.ServerStartInfo) error {
+			if err := server.Close(); err != nil {
+				return err
+			}
+			// allow the server to observe that it has stopped serving
+			time.Sleep(500 * time.Millisecond)
+			return nil
+		}).
+		WithOnStarted(func(ctx context.Context, info witchcraft.ServerStartInfo) error {
+			secondHookCalled = true
+			return nil
+		})
+	defer func() {
+		_ = server.Close()
+	}()
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- server.Start()
+	}()
+	select {
+	case err := <-errC:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+	assert.False(t, secondHookCalled)
+}
+
+// TestServerOnShutdownHookTimeout verifies that on-shutdown hooks that do not complete are abandoned once the shutdown
+// timeout elapses.
+func TestServerOnShutdownHookTimeout(t *testing.T) {
+	createServer := func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+		installCfg.Server.ShutdownTimeout = 100 * time.Millisecond
+		return createTestServer(t, initFn, installCfg, logOutputBuffer).
+			WithInstallConfig(installCfg).
+			WithOnShutdown(func(ctx context.Context) error {
+				time.Sleep(5 * time.Second)
+				return nil
+			})
+	}
+
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	managementPort, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	server, serverErr, cleanup := createAndRunCustomTestServer(t, port, managementPort, nil, ioutil.Discard, createServer)
+	defer func() {
+		_ = server.Close()
+	}()
+	defer cleanup()
+
+	start := time.Now()
+	require.NoError(t, server.Shutdown(context.Background()))
+	assert.True(t, time.Since(start) < 2*time.Second, "shutdown was not bounded by the shutdown timeout")
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/palantir/pkg/httpserver"
+	"github.com/palantir/witchcraft-go-server/v2/config"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft"
+	"github.com/palantir/witchcraft-go-server/v2/wrouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServerReplaceDefaultMiddleware verifies that replaced built-in middleware run at the position of the middleware
+// they replace relative to the remaining built-in middleware and to user-provided middleware.
+func TestServerReplaceDefaultMiddleware(t *testing.T) {
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	recordingMiddleware := func(name string) wrouter.RouteHandlerMiddleware {
+		return func(rw http.ResponseWriter, r *http.Request, reqVals wrouter.RequestVals, next wrouter.RouteRequestHandler) {
+			if strings.HasSuffix(r.URL.Path, "/ordered") {
+				record(name)
+			}
+			next(rw, r, reqVals)
+		}
+	}
+
+	server, serverErr, cleanup := createAndRunCustomTestServer(t, port, port, func(ctx context.Context, info witchcraft.InitInfo) (deferFn func(), rErr error) {
+		return nil, info.Router.Get("/ordered", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			record("handler")
+		}))
+	}, ioutil.Discard, func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+		return createTestServer(t, initFn, installCfg, logOutputBuffer).
+			WithMiddleware(func(rw http.ResponseWriter, r *http.Request, next http.Handler) {
+				if strings.HasSuffix(r.URL.Path, "/ordered") {
+					record("user")
+				}
+				next.ServeHTTP(rw, r)
+			}).
+			WithReplaceDefaultMiddleware(witchcraft.DefaultMiddlewarePanicRecovery, recordingMiddleware("panic-recovery")).
+			WithReplaceDefaultMiddleware(witchcraft.DefaultMiddlewareRequestLog, recordingMiddleware("request-log")).
+			WithReplaceDefaultMiddleware(witchcraft.DefaultMiddlewareTrace, recordingMiddleware("trace")).
+			WithReplaceDefaultMiddleware(witchcraft.DefaultMiddlewareRequestMetrics, recordingMiddleware("request-metrics"))
+	})
+	defer func() {
+		require.NoError(t, server.Close())
+	}()
+	defer cleanup()
+
+	resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d%s/ordered", port, basePath))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"user", "request-metrics", "request-log", "trace", "panic-recovery", "handler"}, calls)
+
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	default:
+	}
+}
+
+// TestServerDisableDefaultMiddleware verifies that disabling the request log and trace middleware stops request log
+// entries and route spans from being written while the remaining built-in middleware continue to apply.
+func TestServerDisableDefaultMiddleware(t *testing.T) {
+	logOutputBuffer := &bytes.Buffer{}
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	server, serverErr, cleanup := createAndRunCustomTestServer(t, port, port, func(ctx context.Context, info witchcraft.InitInfo) (deferFn func(), rErr error) {
+		return nil, info.Router.Get("/panic", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			panic("panic inside handler")
+		}))
+	}, logOutputBuffer, func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+		return createTestServer(t, initFn, installCfg, logOutputBuffer).
+			WithDisableRequestLogMiddleware().
+			WithDisableTraceMiddleware()
+	})
+	defer func() {
+		require.NoError(t, server.Close())
+	}()
+	defer cleanup()
+
+	logOutputBuffer.Reset()
+	resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d%s/ok", port, basePath))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// panics are still recovered by the built-in panic recovery middleware
+	resp, err = testServerClient().Get(fmt.Sprintf("https://localhost:%d%s/panic", port, basePath))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	assert.Empty(t, getLogMessagesOfType(t, "request.2", logOutputBuffer.Bytes()))
+	for _, entry := range getLogMessagesOfType(t, "trace.1", logOutputBuffer.Bytes()) {
+		span, ok := entry["span"].(map[string]interface{})
+		require.True(t, ok, "trace log entry does not contain a span: %v", entry)
+		assert.NotRegexp(t, "^GET /", span["name"], "route span was created: %v", entry)
+	}
+
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	default:
+	}
+}
+
+// TestServerReplaceUnknownDefaultMiddleware verifies that the server fails to start if a middleware name that does not
+// identify a built-in middleware is replaced.
+func TestServerReplaceUnknownDefaultMiddleware(t *testing.T) {
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	err = createTestServer(t, nil, config.Install{
+		ProductName:   productName,
+		UseConsoleLog: true,
+		Server: config.Server{
+			Address:     "localhost",
+			Port:        port,
+			ContextPath: basePath,
+		},
+	}, ioutil.Discard).WithReplaceDefaultMiddleware("unknown", nil).Start()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown default middleware name")
+}
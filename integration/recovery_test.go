@@ -18,18 +18,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/palantir/conjure-go-runtime/v2/conjure-go-client/httpclient"
 	"github.com/palantir/conjure-go-runtime/v2/conjure-go-contract/codecs"
 	"github.com/palantir/conjure-go-runtime/v2/conjure-go-contract/errors"
 	"github.com/palantir/conjure-go-runtime/v2/conjure-go-server/httpserver"
 	pkgserver "github.com/palantir/pkg/httpserver"
+	"github.com/palantir/pkg/metrics"
 	"github.com/palantir/pkg/objmatcher"
+	"github.com/palantir/witchcraft-go-logging/conjure/witchcraft/api/logging"
 	"github.com/palantir/witchcraft-go-server/v2/config"
 	"github.com/palantir/witchcraft-go-server/v2/witchcraft"
 	"github.com/palantir/witchcraft-go-server/v2/witchcraft/refreshable"
@@ -141,7 +146,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"message": objmatcher.NewEqualsMatcher("panic recovered"),
 						"traceId": objmatcher.NewEqualsMatcher(traceID),
 						"params": objmatcher.MapMatcher{
-							"stacktrace": objmatcher.NewAnyMatcher(),
+							"stackFrames": objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic inside handler"),
@@ -157,7 +162,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"traceId": objmatcher.NewEqualsMatcher(traceID),
 						"params": objmatcher.MapMatcher{
 							"errorInstanceId": objmatcher.NewEqualsMatcher(err.InstanceID().String()),
-							"stacktrace":      objmatcher.NewAnyMatcher(),
+							"stackFrames":     objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic inside handler"),
@@ -201,7 +206,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"message": objmatcher.NewEqualsMatcher("panic recovered"),
 						"traceId": objmatcher.NewEqualsMatcher(traceID),
 						"params": objmatcher.MapMatcher{
-							"stacktrace": objmatcher.NewAnyMatcher(),
+							"stackFrames": objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic inside handler after write"),
@@ -217,7 +222,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"traceId": objmatcher.NewEqualsMatcher(traceID),
 						"params": objmatcher.MapMatcher{
 							"errorInstanceId": objmatcher.NewAnyMatcher(),
-							"stacktrace":      objmatcher.NewAnyMatcher(),
+							"stackFrames":     objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic inside handler after write"),
@@ -258,7 +263,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"origin":  objmatcher.NewEqualsMatcher("github.com/palantir/witchcraft-go-server/integration"),
 						"message": objmatcher.NewEqualsMatcher("panic recovered"),
 						"params": objmatcher.MapMatcher{
-							"stacktrace": objmatcher.NewAnyMatcher(),
+							"stackFrames": objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic before handler"),
@@ -273,7 +278,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"message": objmatcher.NewEqualsMatcher(fmt.Sprintf("error handling request: INTERNAL Default:Internal (%s)", err.InstanceID().String())),
 						"params": objmatcher.MapMatcher{
 							"errorInstanceId": objmatcher.NewEqualsMatcher(err.InstanceID().String()),
-							"stacktrace":      objmatcher.NewAnyMatcher(),
+							"stackFrames":     objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic before handler"),
@@ -302,7 +307,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"origin":  objmatcher.NewEqualsMatcher("github.com/palantir/witchcraft-go-server/integration"),
 						"message": objmatcher.NewEqualsMatcher("panic recovered"),
 						"params": objmatcher.MapMatcher{
-							"stacktrace": objmatcher.NewAnyMatcher(),
+							"stackFrames": objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic after handler"),
@@ -317,7 +322,7 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 						"message": objmatcher.NewRegExpMatcher("error handling request: INTERNAL Default:Internal \\(.*\\)"),
 						"params": objmatcher.MapMatcher{
 							"errorInstanceId": objmatcher.NewAnyMatcher(),
-							"stacktrace":      objmatcher.NewAnyMatcher(),
+							"stackFrames":     objmatcher.NewAnyMatcher(),
 						},
 						"unsafeParams": objmatcher.MapMatcher{
 							"recovered": objmatcher.NewEqualsMatcher("panic after handler"),
@@ -375,3 +380,78 @@ func TestServerPanicRecoveryMiddleware(t *testing.T) {
 	default:
 	}
 }
+
+// TestServerPanicMetricsAndGoroutineDump verifies that a recovered panic is logged with structured stack frames, is
+// recorded in the server.panics metric tagged by route, does not leak details of the panic in the response and, if
+// enabled in runtime configuration, results in a goroutine dump being written to the diagnostic log.
+func TestServerPanicMetricsAndGoroutineDump(t *testing.T) {
+	logOutputBuffer := &bytes.Buffer{}
+	port, err := pkgserver.AvailablePort()
+	require.NoError(t, err)
+
+	// ensure that registry used in this test is unique/does not have any past metrics registered on it
+	metrics.DefaultMetricsRegistry = metrics.NewRootMetricsRegistry()
+	server, serverErr, cleanup := createAndRunCustomTestServer(t, port, port, func(ctx context.Context, info witchcraft.InitInfo) (deferFn func(), rErr error) {
+		return nil, info.Router.Get("/panic/{id}", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			panic("secret panic value")
+		}))
+	}, logOutputBuffer, func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+		installCfg.MetricsEmitFrequency = 100 * time.Millisecond
+		return createTestServer(t, initFn, installCfg, logOutputBuffer).
+			WithRuntimeConfigProvider(refreshable.NewDefaultRefreshable([]byte("diagnostics:\n  goroutine-dump-on-panic: true\n")))
+	})
+	defer func() {
+		require.NoError(t, server.Close())
+	}()
+	defer cleanup()
+
+	resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d/%s/panic/1", port, basePath))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.NotContains(t, string(body), "secret panic value")
+	assert.NotContains(t, string(body), ".go")
+
+	// allow the metric emitter to run
+	time.Sleep(150 * time.Millisecond)
+
+	var panicLogged bool
+	for _, entry := range getLogMessagesOfType(t, "service.1", logOutputBuffer.Bytes()) {
+		if entry["message"] != "panic recovered" {
+			continue
+		}
+		panicLogged = true
+		frames, ok := entry["params"].(map[string]interface{})["stackFrames"].([]interface{})
+		require.True(t, ok, "stackFrames param is not a list: %v", entry)
+		require.NotEmpty(t, frames)
+		frame := frames[0].(map[string]interface{})
+		assert.Contains(t, frame["function"], "TestServerPanicMetricsAndGoroutineDump")
+		assert.Contains(t, frame["file"], "recovery_test.go")
+		assert.NotZero(t, frame["line"])
+	}
+	assert.True(t, panicLogged, "panic was not logged")
+
+	var panicCount json.Number
+	for _, curr := range strings.Split(logOutputBuffer.String(), "\n") {
+		if !strings.Contains(curr, `"metric.1"`) {
+			continue
+		}
+		var metricLog logging.MetricLogV1
+		require.NoError(t, json.Unmarshal([]byte(curr), &metricLog))
+		// tag values are normalized, so the braces of the path template are replaced
+		if metricLog.MetricName == "server.panics" && metricLog.Tags["route"] == "/example/panic/_id_" {
+			panicCount = metricLog.Values["count"].(json.Number)
+		}
+	}
+	assert.Equal(t, json.Number("1"), panicCount)
+
+	assert.NotEmpty(t, getLogMessagesOfType(t, "diagnostic.1", logOutputBuffer.Bytes()), "goroutine dump was not written to the diagnostic log")
+
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	default:
+	}
+}
@@ -20,10 +20,14 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -149,6 +153,316 @@ func TestServerShutdown(t *testing.T) {
 	})
 }
 
+// TestServerShutdownSignal verifies the behavior when a Witchcraft server is shut down by a SIGTERM. In-flight requests
+// that complete within the configured shutdown timeout are allowed to finish before the cleanup function returned by
+// the init function is run, while requests that outlive the timeout are terminated.
+func TestServerShutdownSignal(t *testing.T) {
+	runTest := func(t *testing.T, handlerDuration, shutdownTimeout time.Duration, graceful bool) {
+		var eventsMutex sync.Mutex
+		var events []string
+		recordEvent := func(event string) {
+			eventsMutex.Lock()
+			defer eventsMutex.Unlock()
+			events = append(events, event)
+		}
+
+		calledC := make(chan bool, 1)
+		initFn := func(ctx context.Context, info witchcraft.InitInfo) (func(), error) {
+			return func() {
+					recordEvent("cleanup")
+				}, info.Router.Get("/wait", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					calledC <- true
+					time.Sleep(handlerDuration)
+					recordEvent("handler done")
+				}))
+		}
+		createServer := func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+			installCfg.Server.ShutdownTimeout = shutdownTimeout
+			return createTestServer(t, initFn, installCfg, logOutputBuffer).WithInstallConfig(installCfg)
+		}
+
+		port, err := httpserver.AvailablePort()
+		require.NoError(t, err)
+		managementPort, err := httpserver.AvailablePort()
+		require.NoError(t, err)
+		server, serverErr, cleanup := createAndRunCustomTestServer(t, port, managementPort, initFn, ioutil.Discard, createServer)
+		defer func() {
+			_ = server.Close()
+		}()
+		defer cleanup()
+
+		reqErrC := make(chan error, 1)
+		go func() {
+			resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d/example/wait", port))
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			reqErrC <- err
+		}()
+
+		select {
+		case <-calledC:
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for called")
+		}
+
+		proc, err := os.FindProcess(os.Getpid())
+		require.NoError(t, err)
+		require.NoError(t, proc.Signal(syscall.SIGTERM))
+
+		select {
+		case err := <-serverErr:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for server to stop")
+		}
+
+		eventsMutex.Lock()
+		stopEvents := append([]string(nil), events...)
+		eventsMutex.Unlock()
+
+		if graceful {
+			assert.Equal(t, []string{"handler done", "cleanup"}, stopEvents)
+			assert.NoError(t, <-reqErrC)
+		} else {
+			assert.Equal(t, []string{"cleanup"}, stopEvents)
+			assert.Error(t, <-reqErrC)
+		}
+	}
+
+	t.Run("drains in-flight requests", func(t *testing.T) {
+		runTest(t, 500*time.Millisecond, 5*time.Second, true)
+	})
+	t.Run("closes connections after shutdown timeout", func(t *testing.T) {
+		runTest(t, 3*time.Second, 100*time.Millisecond, false)
+	})
+}
+
+// TestServerShutdownSignalManagementDrain verifies that the shutdown timeout bounds the whole of a shutdown triggered by
+// a SIGTERM: an on-shutdown hook that runs until the timeout elapses followed by an in-flight request to the dedicated
+// management server that outlives it does not extend the shutdown beyond the shutdown timeout.
+func TestServerShutdownSignalManagementDrain(t *testing.T) {
+	const shutdownTimeout = time.Second
+	livenessCalledC := make(chan bool, 1)
+	createServer := func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+		installCfg.Server.ShutdownTimeout = shutdownTimeout
+		return createTestServer(t, initFn, installCfg, logOutputBuffer).
+			WithInstallConfig(installCfg).
+			WithLiveness(blockingSource{calledC: livenessCalledC, duration: 5 * time.Second}).
+			WithOnShutdown(func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			})
+	}
+
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	managementPort, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+	server, serverErr, cleanup := createAndRunCustomTestServer(t, port, managementPort, nil, ioutil.Discard, createServer)
+	defer func() {
+		_ = server.Close()
+	}()
+	defer cleanup()
+
+	go func() {
+		resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d/%s/%s", managementPort, basePath, status.LivenessEndpoint))
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	select {
+	case <-livenessCalledC:
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for liveness request")
+	}
+
+	proc, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, proc.Signal(syscall.SIGTERM))
+
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+	assert.True(t, time.Since(start) < shutdownTimeout+500*time.Millisecond, "shutdown was not bounded by the shutdown timeout")
+}
+
+// blockingSource is a status source that notifies calledC when it is called and then blocks for duration.
+type blockingSource struct {
+	calledC  chan<- bool
+	duration time.Duration
+}
+
+func (s blockingSource) Status() (int, interface{}) {
+	s.calledC <- true
+	time.Sleep(s.duration)
+	return http.StatusOK, nil
+}
+
+// TestServerUnixSocket verifies that a Witchcraft server configured to listen on a Unix domain socket removes a stale
+// socket on startup, creates the socket with the configured file mode, serves requests over it and removes the socket
+// when it stops. It also verifies that the server refuses to start if a file that is not a socket exists at the path.
+func TestServerUnixSocket(t *testing.T) {
+	dir, cleanup, err := dirs.TempDir("", "test-server")
+	require.NoError(t, err)
+	defer cleanup()
+
+	socketPath := path.Join(dir, "server.sock")
+	installCfg := config.Install{
+		ProductName:   productName,
+		UseConsoleLog: true,
+		Server: config.Server{
+			Listen:         "unix://" + socketPath,
+			SocketFileMode: 0600,
+			ContextPath:    basePath,
+		},
+	}
+
+	t.Run("serves requests over socket", func(t *testing.T) {
+		// create a stale socket that is not removed when its listener is closed
+		staleListener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		staleListener.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, staleListener.Close())
+		_, err = os.Lstat(socketPath)
+		require.NoError(t, err)
+
+		server := createTestServer(t, nil, installCfg, ioutil.Discard)
+		defer func() {
+			_ = server.Close()
+		}()
+		serverErr := make(chan error, 1)
+		go func() {
+			serverErr <- server.Start()
+		}()
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		}}
+		ready := <-httpserver.Ready(func() (*http.Response, error) {
+			return client.Get("https://localhost/example/ok")
+		}, httpserver.WaitTimeoutParam(5*time.Second))
+		require.True(t, ready, "timed out waiting for server to start")
+
+		fi, err := os.Lstat(socketPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+		require.NoError(t, server.Close())
+		select {
+		case err := <-serverErr:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for server to stop")
+		}
+		_, err = os.Lstat(socketPath)
+		assert.True(t, os.IsNotExist(err), "socket was not removed when server stopped")
+	})
+
+	t.Run("does not remove file that is not a socket", func(t *testing.T) {
+		require.NoError(t, ioutil.WriteFile(socketPath, []byte("not a socket"), 0644))
+
+		server := createTestServer(t, nil, installCfg, ioutil.Discard)
+		defer func() {
+			_ = server.Close()
+		}()
+		assert.Error(t, server.Start())
+
+		content, err := ioutil.ReadFile(socketPath)
+		require.NoError(t, err)
+		assert.Equal(t, "not a socket", string(content))
+	})
+}
+
+// TestServerTimeouts verifies that the timeouts in the server install configuration are applied to the HTTP server and
+// that negative timeouts are rejected.
+func TestServerTimeouts(t *testing.T) {
+	t.Run("read header timeout closes connections", func(t *testing.T) {
+		port, err := httpserver.AvailablePort()
+		require.NoError(t, err)
+		server, serverErr, cleanup := createAndRunCustomTestServer(t, port, port, nil, ioutil.Discard, func(t *testing.T, initFn witchcraft.InitFunc, installCfg config.Install, logOutputBuffer io.Writer) *witchcraft.Server {
+			installCfg.Server.Timeouts.ReadHeaderTimeout = 100 * time.Millisecond
+			return createTestServer(t, initFn, installCfg, logOutputBuffer)
+		})
+		defer func() {
+			_ = server.Close()
+		}()
+		defer cleanup()
+
+		conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		// send an incomplete request: the server should close the connection once the read header timeout elapses
+		_, err = conn.Write([]byte("GET /example/ok HTTP/1.1\r\n"))
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		start := time.Now()
+		_, err = conn.Read(make([]byte, 1))
+		require.Error(t, err)
+		assert.False(t, isTimeout(err), "connection was not closed by server before read deadline")
+		assert.True(t, time.Since(start) < 2*time.Second)
+
+		select {
+		case err := <-serverErr:
+			require.NoError(t, err)
+		default:
+		}
+	})
+
+	t.Run("negative timeout is rejected", func(t *testing.T) {
+		port, err := httpserver.AvailablePort()
+		require.NoError(t, err)
+		server := createTestServer(t, nil, config.Install{
+			ProductName:   productName,
+			UseConsoleLog: true,
+			Server: config.Server{
+				Address:     "localhost",
+				Port:        port,
+				ContextPath: basePath,
+				Timeouts: config.Timeouts{
+					WriteTimeout: -time.Second,
+				},
+			},
+		}, ioutil.Discard)
+		err = server.Start()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "server timeout must not be negative")
+	})
+
+	t.Run("negative shutdown timeout is rejected", func(t *testing.T) {
+		port, err := httpserver.AvailablePort()
+		require.NoError(t, err)
+		server := createTestServer(t, nil, config.Install{
+			ProductName:   productName,
+			UseConsoleLog: true,
+			Server: config.Server{
+				Address:         "localhost",
+				Port:            port,
+				ContextPath:     basePath,
+				ShutdownTimeout: -time.Second,
+			},
+		}, ioutil.Discard)
+		err = server.Start()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "server timeout must not be negative")
+	})
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
 // TestEmptyPathHandler verifies that a route registered at the default path ("/") is served correctly.
 func TestEmptyPathHandler(t *testing.T) {
 	logOutputBuffer := &bytes.Buffer{}
@@ -249,6 +563,81 @@ func TestManagementRoutes(t *testing.T) {
 	})
 }
 
+// TestManagementRoutesOnDedicatedPort verifies that, when a dedicated management port is configured, the status and
+// debug routes are only served on the management port and the server fails to start if the management port cannot be
+// bound.
+func TestManagementRoutesOnDedicatedPort(t *testing.T) {
+	t.Run("routes are only served on management port", func(t *testing.T) {
+		server, port, managementPort, serverErr, cleanup := createAndRunTestServer(t, nil, ioutil.Discard)
+		defer func() {
+			_ = server.Close()
+		}()
+		defer cleanup()
+
+		for _, currCase := range []struct {
+			path           string
+			mgmtStatusCode int
+		}{
+			{path: status.LivenessEndpoint, mgmtStatusCode: http.StatusOK},
+			{path: status.ReadinessEndpoint, mgmtStatusCode: http.StatusOK},
+			{path: status.HealthEndpoint, mgmtStatusCode: http.StatusOK},
+			{path: "/debug/pprof/", mgmtStatusCode: http.StatusOK},
+			{path: "/debug/diagnostic/go.goroutines.v1", mgmtStatusCode: http.StatusForbidden},
+		} {
+			resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d/example%s", port, currCase.path))
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, "main port should not serve %s", currCase.path)
+
+			resp, err = testServerClient().Get(fmt.Sprintf("https://localhost:%d/example%s", managementPort, currCase.path))
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, currCase.mgmtStatusCode, resp.StatusCode, "unexpected status for %s on management port", currCase.path)
+		}
+
+		select {
+		case err := <-serverErr:
+			require.NoError(t, err)
+		default:
+		}
+	})
+
+	t.Run("fails to start if management port is in use", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		defer func() {
+			_ = ln.Close()
+		}()
+		port, err := httpserver.AvailablePort()
+		require.NoError(t, err)
+
+		server := createTestServer(t, nil, config.Install{
+			ProductName:   productName,
+			UseConsoleLog: true,
+			Server: config.Server{
+				Address:        "localhost",
+				Port:           port,
+				ManagementPort: ln.Addr().(*net.TCPAddr).Port,
+				ContextPath:    basePath,
+			},
+		}, ioutil.Discard)
+		defer func() {
+			_ = server.Close()
+		}()
+
+		errC := make(chan error, 1)
+		go func() {
+			errC <- server.Start()
+		}()
+		select {
+		case err := <-errC:
+			assert.Error(t, err)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "server started despite its management port already being in use")
+		}
+	})
+}
+
 // TestClientTLS verifies that a Witchcraft server configured to require client TLS authentication enforces that config.
 func TestClientTLS(t *testing.T) {
 	testDir, cleanup, err := dirs.TempDir("", "")
@@ -0,0 +1,379 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/nmiyake/pkg/dirs"
+	"github.com/palantir/pkg/httpserver"
+	"github.com/palantir/pkg/refreshable"
+	"github.com/palantir/pkg/tlsconfig"
+	"github.com/palantir/witchcraft-go-health/conjure/witchcraft/api/health"
+	"github.com/palantir/witchcraft-go-server/v2/config"
+	"github.com/palantir/witchcraft-go-server/v2/status"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestServerCertificateReload verifies that the server serves a certificate that is replaced on disk without being
+// restarted, logs the certificates that it loads and continues to serve the previous certificate while reporting
+// an unhealthy health check if the files on disk do not contain a valid key pair.
+func TestServerCertificateReload(t *testing.T) {
+	dir, cleanup, err := dirs.TempDir("", "test-server-cert")
+	require.NoError(t, err)
+	defer cleanup()
+
+	certFile := path.Join(dir, "server-cert.pem")
+	keyFile := path.Join(dir, "server-key.pem")
+	copyTestdataFile(t, "server-cert.pem", certFile)
+	copyTestdataFile(t, "server-key.pem", keyFile)
+
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	logOutputBuffer := &bytes.Buffer{}
+	server := witchcraft.NewServer().
+		WithECVKeyProvider(witchcraft.ECVKeyNoOp()).
+		WithRuntimeConfigProvider(refreshable.NewDefaultRefreshable([]byte{})).
+		WithDisableGoRuntimeMetrics().
+		WithLoggerStdoutWriter(logOutputBuffer).
+		WithInstallConfig(config.Install{
+			ProductName:   productName,
+			UseConsoleLog: true,
+			Server: config.Server{
+				Address:     "localhost",
+				Port:        port,
+				ContextPath: basePath,
+				CertFile:    certFile,
+				KeyFile:     keyFile,
+			},
+		})
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+	if ready := <-waitForTestServerReady(port, path.Join(basePath, status.LivenessEndpoint), 5*time.Second); !ready {
+		select {
+		case err := <-serverErr:
+			require.NoError(t, err)
+		default:
+		}
+		require.Fail(t, "timed out waiting for server to start")
+	}
+
+	serverCert := readTestdataCertificate(t, "server-cert.pem")
+	assert.Equal(t, serverCert.Raw, servedCertificate(t, port).Raw)
+	assert.Equal(t, health.HealthState_HEALTHY, certificateReloadHealthState(t, port))
+
+	// replace the key pair on disk: the new certificate should be served once the change is observed
+	copyTestdataFile(t, "client-key.pem", keyFile)
+	copyTestdataFile(t, "client-cert.pem", certFile)
+	rotatedCert := readTestdataCertificate(t, "client-cert.pem")
+	assert.Eventually(t, func() bool {
+		return bytes.Equal(rotatedCert.Raw, servedCertificate(t, port).Raw)
+	}, 10*time.Second, 100*time.Millisecond, "rotated certificate was not served")
+	assert.Equal(t, health.HealthState_HEALTHY, certificateReloadHealthState(t, port))
+
+	// replace the key with one that does not match the certificate: the previous certificate should still be served
+	copyTestdataFile(t, "server-key.pem", keyFile)
+	assert.Eventually(t, func() bool {
+		return certificateReloadHealthState(t, port) == health.HealthState_ERROR
+	}, 10*time.Second, 100*time.Millisecond, "invalid key pair was not reported by health check")
+	assert.Equal(t, rotatedCert.Raw, servedCertificate(t, port).Raw)
+
+	require.NoError(t, server.Close())
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+
+	var loadedFingerprints []string
+	for _, entry := range getLogMessagesOfType(t, "service.1", logOutputBuffer.Bytes()) {
+		if entry["message"] != "Loaded server certificate" {
+			continue
+		}
+		params := entry["params"].(map[string]interface{})
+		assert.NotEmpty(t, params["notAfter"])
+		loadedFingerprints = append(loadedFingerprints, params["sha256Fingerprint"].(string))
+	}
+	assert.Equal(t, []string{sha256Fingerprint(serverCert), sha256Fingerprint(rotatedCert)}, loadedFingerprints)
+}
+
+func copyTestdataFile(t *testing.T, name, dst string) {
+	content, err := ioutil.ReadFile(path.Join("testdata", name))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(dst, content, 0644))
+}
+
+func readTestdataCertificate(t *testing.T, name string) *x509.Certificate {
+	content, err := ioutil.ReadFile(path.Join("testdata", name))
+	require.NoError(t, err)
+	block, _ := pem.Decode(content)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return cert
+}
+
+// servedCertificate returns the leaf certificate presented by the server on a new TLS connection.
+func servedCertificate(t *testing.T, port int) *x509.Certificate {
+	conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	peerCerts := conn.ConnectionState().PeerCertificates
+	require.NotEmpty(t, peerCerts)
+	return peerCerts[0]
+}
+
+func certificateReloadHealthState(t *testing.T, port int) health.HealthState_Value {
+	resp, err := testServerClient().Get(fmt.Sprintf("https://localhost:%d/%s/%s", port, basePath, status.HealthEndpoint))
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var healthResults health.HealthStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&healthResults))
+	result, ok := healthResults.Checks["SERVER_CERTIFICATE_RELOAD"]
+	require.True(t, ok, "health status does not contain SERVER_CERTIFICATE_RELOAD check: %v", healthResults)
+	return result.State.Value()
+}
+
+func sha256Fingerprint(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// TestServerMutualTLS verifies that a server configured to require and verify client certificates exposes the verified
+// client certificate to handlers, reloads its client CAs when the CA file changes and records requests whose
+// certificates fail verification in the request log.
+func TestServerMutualTLS(t *testing.T) {
+	dir, cleanup, err := dirs.TempDir("", "test-server-mtls")
+	require.NoError(t, err)
+	defer cleanup()
+
+	caFile := path.Join(dir, "ca-cert.pem")
+	copyTestdataFile(t, "ca-cert.pem", caFile)
+
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	logOutputBuffer := &bytes.Buffer{}
+	server := witchcraft.NewServer().
+		WithECVKeyProvider(witchcraft.ECVKeyNoOp()).
+		WithRuntimeConfigProvider(refreshable.NewDefaultRefreshable([]byte{})).
+		WithDisableGoRuntimeMetrics().
+		WithLoggerStdoutWriter(logOutputBuffer).
+		WithInstallConfig(config.Install{
+			ProductName:   productName,
+			UseConsoleLog: true,
+			Server: config.Server{
+				Address:        "localhost",
+				Port:           port,
+				ContextPath:    basePath,
+				CertFile:       path.Join("testdata", "server-cert.pem"),
+				KeyFile:        path.Join("testdata", "server-key.pem"),
+				ClientAuthType: config.ClientAuthTypeRequireAndVerify,
+				ClientCAFiles:  []string{caFile},
+			},
+		}).
+		WithInitFunc(func(ctx context.Context, info witchcraft.InitInfo) (func(), error) {
+			return nil, info.Router.Get("/client", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				var commonName string
+				if clientCert := witchcraft.ClientCertFromContext(req.Context()); clientCert != nil {
+					commonName = clientCert.Subject.CommonName
+				}
+				_, _ = fmt.Fprint(rw, commonName)
+			}))
+		})
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	tlsConf, err := tlsconfig.NewClientConfig(
+		tlsconfig.ClientRootCAFiles(path.Join("testdata", "ca-cert.pem")),
+		tlsconfig.ClientKeyPairFiles(path.Join("testdata", "client-cert.pem"), path.Join("testdata", "client-key.pem")),
+	)
+	require.NoError(t, err)
+	mtlsGet := func() (int, string, error) {
+		// use a new transport for every request so that every request performs a new TLS handshake
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}).Get(fmt.Sprintf("https://localhost:%d%s/client", port, basePath))
+		if err != nil {
+			return 0, "", err
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		body, err := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+	if ready := <-httpserver.Ready(func() (*http.Response, error) {
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}).Get(fmt.Sprintf("https://localhost:%d%s/%s", port, basePath, status.LivenessEndpoint))
+		return resp, err
+	}, httpserver.WaitTimeoutParam(5*time.Second)); !ready {
+		select {
+		case err := <-serverErr:
+			require.NoError(t, err)
+		default:
+		}
+		require.Fail(t, "timed out waiting for server to start")
+	}
+
+	statusCode, body, err := mtlsGet()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "client", body)
+
+	_, err = testServerClient().Get(fmt.Sprintf("https://localhost:%d%s/client", port, basePath))
+	require.Error(t, err, "client allowed to make request without certificate")
+
+	// replace the CA with a certificate that did not sign the client certificate: requests should be rejected
+	copyTestdataFile(t, "server-cert.pem", caFile)
+	assert.Eventually(t, func() bool {
+		statusCode, _, err := mtlsGet()
+		return err == nil && statusCode == http.StatusForbidden
+	}, 10*time.Second, 100*time.Millisecond, "client certificate was not rejected after client CAs were reloaded")
+
+	require.NoError(t, server.Close())
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+
+	var rejected bool
+	for _, entry := range getLogMessagesOfType(t, "request.2", logOutputBuffer.Bytes()) {
+		if entry["path"] == basePath+"/client" && entry["status"] == float64(http.StatusForbidden) {
+			rejected = true
+		}
+	}
+	assert.True(t, rejected, "rejected request was not recorded in the request log")
+}
+
+// TestServerMutualTLSRequestLogDisabled verifies that requests whose client certificates fail verification are not
+// written to the request log if the request log middleware is disabled.
+func TestServerMutualTLSRequestLogDisabled(t *testing.T) {
+	dir, cleanup, err := dirs.TempDir("", "test-server-mtls")
+	require.NoError(t, err)
+	defer cleanup()
+
+	caFile := path.Join(dir, "ca-cert.pem")
+	copyTestdataFile(t, "ca-cert.pem", caFile)
+
+	port, err := httpserver.AvailablePort()
+	require.NoError(t, err)
+
+	logOutputBuffer := &bytes.Buffer{}
+	server := witchcraft.NewServer().
+		WithECVKeyProvider(witchcraft.ECVKeyNoOp()).
+		WithRuntimeConfigProvider(refreshable.NewDefaultRefreshable([]byte{})).
+		WithDisableGoRuntimeMetrics().
+		WithLoggerStdoutWriter(logOutputBuffer).
+		WithDisableRequestLogMiddleware().
+		WithInstallConfig(config.Install{
+			ProductName:   productName,
+			UseConsoleLog: true,
+			Server: config.Server{
+				Address:        "localhost",
+				Port:           port,
+				ContextPath:    basePath,
+				CertFile:       path.Join("testdata", "server-cert.pem"),
+				KeyFile:        path.Join("testdata", "server-key.pem"),
+				ClientAuthType: config.ClientAuthTypeRequireAndVerify,
+				ClientCAFiles:  []string{caFile},
+			},
+		})
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	tlsConf, err := tlsconfig.NewClientConfig(
+		tlsconfig.ClientRootCAFiles(path.Join("testdata", "ca-cert.pem")),
+		tlsconfig.ClientKeyPairFiles(path.Join("testdata", "client-cert.pem"), path.Join("testdata", "client-key.pem")),
+	)
+	require.NoError(t, err)
+	livenessStatus := func() int {
+		// use a new transport for every request so that every request performs a new TLS handshake
+		resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}).Get(fmt.Sprintf("https://localhost:%d%s%s", port, basePath, status.LivenessEndpoint))
+		if err != nil {
+			return 0
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	if ready := assert.Eventually(t, func() bool {
+		return livenessStatus() == http.StatusOK
+	}, 5*time.Second, 100*time.Millisecond, "timed out waiting for server to start"); !ready {
+		select {
+		case err := <-serverErr:
+			require.NoError(t, err)
+		default:
+		}
+		require.FailNow(t, "server did not start")
+	}
+
+	// replace the CA with a certificate that did not sign the client certificate: requests should be rejected
+	copyTestdataFile(t, "server-cert.pem", caFile)
+	assert.Eventually(t, func() bool {
+		return livenessStatus() == http.StatusForbidden
+	}, 10*time.Second, 100*time.Millisecond, "client certificate was not rejected after client CAs were reloaded")
+
+	require.NoError(t, server.Close())
+	select {
+	case err := <-serverErr:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "timed out waiting for server to stop")
+	}
+
+	var rejectionLogged bool
+	for _, entry := range getLogMessagesOfType(t, "service.1", logOutputBuffer.Bytes()) {
+		if entry["message"] == "Rejecting request: client certificate verification failed" {
+			rejectionLogged = true
+		}
+	}
+	assert.True(t, rejectionLogged, "rejected request was not logged")
+	assert.Empty(t, getLogMessagesOfType(t, "request.2", logOutputBuffer.Bytes()))
+}
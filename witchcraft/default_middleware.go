@@ -0,0 +1,80 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package witchcraft
+
+import (
+	werror "github.com/palantir/witchcraft-go-error"
+	"github.com/palantir/witchcraft-go-server/v2/wrouter"
+)
+
+// DefaultMiddlewareName is the stable name of a built-in route middleware that can be disabled or replaced using
+// WithReplaceDefaultMiddleware.
+type DefaultMiddlewareName string
+
+const (
+	// DefaultMiddlewareRequestMetrics is the middleware that records the "server.response" metrics for routed requests.
+	DefaultMiddlewareRequestMetrics DefaultMiddlewareName = "request-metrics"
+	// DefaultMiddlewareRequestLog is the middleware that writes a request log entry for every routed request.
+	DefaultMiddlewareRequestLog DefaultMiddlewareName = "request-log"
+	// DefaultMiddlewareTrace is the middleware that creates a span (and writes a trace log entry if the span is sampled)
+	// for every routed request. The root span created for every request by the request middleware is not affected.
+	DefaultMiddlewareTrace DefaultMiddlewareName = "trace"
+	// DefaultMiddlewarePanicRecovery is the middleware that recovers panics in route handlers with the loggers and
+	// trace information of the request. Panics that are not recovered by this middleware are still recovered by the
+	// outermost request panic recovery middleware, which cannot be replaced.
+	DefaultMiddlewarePanicRecovery DefaultMiddlewareName = "panic-recovery"
+)
+
+var defaultMiddlewareNames = []DefaultMiddlewareName{
+	DefaultMiddlewareRequestMetrics,
+	DefaultMiddlewareRequestLog,
+	DefaultMiddlewareTrace,
+	DefaultMiddlewarePanicRecovery,
+}
+
+// validateDefaultMiddlewareOverrides returns an error if overrides contains a name that does not identify a built-in
+// middleware.
+func validateDefaultMiddlewareOverrides(overrides map[DefaultMiddlewareName]wrouter.RouteHandlerMiddleware) error {
+	for name := range overrides {
+		var known bool
+		for _, defaultName := range defaultMiddlewareNames {
+			if name == defaultName {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return werror.Error("unknown default middleware name",
+				werror.SafeParam("name", string(name)),
+				werror.SafeParam("supportedNames", defaultMiddlewareNames))
+		}
+	}
+	return nil
+}
+
+// addDefaultRouteMiddleware adds the built-in route middleware with the provided name to rootRouter: if the middleware
+// has been replaced using WithReplaceDefaultMiddleware, the replacement is added instead (or nothing is added if the
+// middleware has been disabled). The replacement occupies the position of the built-in middleware, so the order of the
+// remaining built-in middleware relative to each other and to user-provided middleware is preserved.
+func (s *Server) addDefaultRouteMiddleware(rootRouter wrouter.RootRouter, name DefaultMiddlewareName, defaultMiddleware wrouter.RouteHandlerMiddleware) {
+	middleware, replaced := s.defaultMiddlewareOverrides[name]
+	if !replaced {
+		middleware = defaultMiddleware
+	}
+	if middleware == nil {
+		return
+	}
+	rootRouter.AddRouteHandlerMiddleware(middleware)
+}
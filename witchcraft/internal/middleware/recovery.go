@@ -0,0 +1,170 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"runtime"
+
+	"github.com/palantir/conjure-go-runtime/v2/conjure-go-contract/errors"
+	"github.com/palantir/conjure-go-runtime/v2/conjure-go-server/httpserver"
+	"github.com/palantir/pkg/metrics"
+	"github.com/palantir/pkg/refreshable"
+	werror "github.com/palantir/witchcraft-go-error"
+	"github.com/palantir/witchcraft-go-logging/conjure/witchcraft/api/logging"
+	"github.com/palantir/witchcraft-go-logging/wlog/diaglog/diag1log"
+	"github.com/palantir/witchcraft-go-logging/wlog/evtlog/evt2log"
+	"github.com/palantir/witchcraft-go-logging/wlog/svclog/svc1log"
+)
+
+const (
+	serverPanicsMetricName = "server.panics"
+	routeTagName           = "route"
+	unknownRouteTagValue   = "unknown"
+
+	// maxPanicStackFrames is the maximum number of frames of the panicking goroutine that are logged.
+	maxPanicStackFrames = 64
+	// maxPanicGoroutineDumpBytes is the maximum size of the goroutine dump written when a panic is recovered.
+	maxPanicGoroutineDumpBytes = 1 << 20
+)
+
+// panicRecoveryParams specifies how panics recovered by panicRecoveryMiddleware are reported. Loggers and the registry
+// are taken from the request context if they are nil.
+type panicRecoveryParams struct {
+	svcLogger            svc1log.Logger
+	evtLogger            evt2log.Logger
+	diagLogger           diag1log.Logger
+	registry             metrics.Registry
+	route                string
+	goroutineDumpOnPanic refreshable.Bool
+}
+
+// panicStackFrame is a single frame of the stack of a goroutine that panicked.
+type panicStackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// panicRecoveryMiddleware calls nextFunc and recovers any panic that occurs. A recovered panic is logged with the
+// stack frames of the panicking goroutine as a safe parameter, recorded in the "server.panics" metric and, if
+// configured, reported with a dump of all goroutines to the diagnostic logger. If nothing has been written to the
+// response yet, a generic internal error is written: the response never contains information about the panic.
+func panicRecoveryMiddleware(lrw loggingResponseWriter, req *http.Request, params panicRecoveryParams, nextFunc func()) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		stackFrames := panicStackFrames()
+
+		ctx := req.Context() // ctx changes are used within this middleware but not stored to the request
+		if params.svcLogger != nil {
+			ctx = svc1log.WithLogger(ctx, params.svcLogger)
+		}
+		if params.evtLogger != nil {
+			ctx = evt2log.WithLogger(ctx, params.evtLogger)
+		}
+
+		var err error
+		if recoveredErr, ok := r.(error); ok {
+			svc1log.FromContext(ctx).Error("panic recovered",
+				svc1log.SafeParam("stackFrames", stackFrames),
+				svc1log.Stacktrace(recoveredErr))
+			err = werror.Wrap(recoveredErr, "panic recovered", werror.SafeParam("stackFrames", stackFrames))
+		} else {
+			svc1log.FromContext(ctx).Error("panic recovered",
+				svc1log.SafeParam("stackFrames", stackFrames),
+				svc1log.UnsafeParam("recovered", r))
+			err = werror.Error("panic recovered", werror.SafeParam("stackFrames", stackFrames), werror.UnsafeParam("recovered", r))
+		}
+		if evtLogger := evt2log.FromContext(ctx); evtLogger != nil {
+			evtLogger.Event("wapp.panic_recovered",
+				evt2log.Value("stackFrames", stackFrames),
+				evt2log.UnsafeParam("recovered", r))
+		}
+
+		registry := params.registry
+		if registry == nil {
+			registry = metrics.FromContext(ctx)
+		}
+		route := params.route
+		if route == "" {
+			route = unknownRouteTagValue
+		}
+		registry.Counter(serverPanicsMetricName, metrics.NewTagWithFallbackValue(routeTagName, route, unknownRouteTagValue)).Inc(1)
+
+		if params.goroutineDumpOnPanic != nil && params.goroutineDumpOnPanic.CurrentBool() {
+			diagLogger := params.diagLogger
+			if diagLogger == nil {
+				diagLogger = diag1log.FromContext(ctx)
+			}
+			diagLogger.Diagnostic(logging.NewDiagnosticFromThreadDump(diag1log.ThreadDumpV1FromGoroutines(boundedGoroutineDump())))
+		}
+
+		cerr := errors.WrapWithInternal(err)
+		httpserver.ErrHandler(ctx, cerr.Code().StatusCode(), cerr)
+
+		// Only write to response if we have not written anything yet
+		if !lrw.Written() {
+			errors.WriteErrorResponse(lrw, cerr)
+		}
+	}()
+	nextFunc()
+}
+
+// panicStackFrames returns the frames of the stack of the calling goroutine, which must be panicking, starting at the
+// frame that panicked.
+func panicStackFrames() []panicStackFrame {
+	pcs := make([]uintptr, maxPanicStackFrames+16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(0, pcs)])
+
+	var allFrames, panicFrames []panicStackFrame
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			// discard the frames of this function and of the runtime that handles the panic
+			panicFrames = []panicStackFrame{}
+		} else if panicFrames != nil {
+			panicFrames = append(panicFrames, panicStackFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		}
+		allFrames = append(allFrames, panicStackFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		if !more {
+			break
+		}
+	}
+	if panicFrames == nil {
+		panicFrames = allFrames
+	}
+	if len(panicFrames) > maxPanicStackFrames {
+		panicFrames = panicFrames[:maxPanicStackFrames]
+	}
+	return panicFrames
+}
+
+// boundedGoroutineDump returns the stacks of all goroutines, truncated after the last complete goroutine that fits
+// within maxPanicGoroutineDumpBytes.
+func boundedGoroutineDump() []byte {
+	buf := make([]byte, maxPanicGoroutineDumpBytes)
+	n := runtime.Stack(buf, true)
+	if n < len(buf) {
+		return buf[:n]
+	}
+	if idx := bytes.LastIndex(buf, []byte("\n\ngoroutine ")); idx > 0 {
+		return buf[:idx+1]
+	}
+	return buf
+}
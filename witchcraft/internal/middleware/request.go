@@ -15,17 +15,22 @@
 package middleware
 
 import (
+	"context"
+	"crypto/x509"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/palantir/conjure-go-runtime/v2/conjure-go-contract/errors"
 	"github.com/palantir/pkg/metrics"
+	"github.com/palantir/pkg/refreshable"
 	"github.com/palantir/witchcraft-go-logging/wlog"
 	"github.com/palantir/witchcraft-go-logging/wlog/auditlog/audit2log"
 	"github.com/palantir/witchcraft-go-logging/wlog/diaglog/diag1log"
 	"github.com/palantir/witchcraft-go-logging/wlog/evtlog/evt2log"
 	"github.com/palantir/witchcraft-go-logging/wlog/extractor"
 	"github.com/palantir/witchcraft-go-logging/wlog/metriclog/metric1log"
+	"github.com/palantir/witchcraft-go-logging/wlog/reqlog/req2log"
 	"github.com/palantir/witchcraft-go-logging/wlog/svclog/svc1log"
 	"github.com/palantir/witchcraft-go-logging/wlog/trclog/trc1log"
 	"github.com/palantir/witchcraft-go-server/v2/wrouter"
@@ -35,13 +40,27 @@ import (
 )
 
 // NewRequestPanicRecovery returns a middleware which recovers panics in the wrapped handler.
-// It accepts loggers as arguments, as we are not guaranteed they have been set on the request context.
-// These loggers are only used in the case of a panic.
+// It accepts loggers and the metrics registry as arguments, as we are not guaranteed they have been set on the request
+// context. These are only used in the case of a panic, which is recorded in the "server.panics" metric with an
+// "unknown" route tag. If goroutineDumpOnPanic is non-nil and true, a dump of all goroutines is written to diagLogger
+// whenever a panic is recovered.
 // When this is the outermost middleware, some request information (e.g. trace ids) will not be set.
-func NewRequestPanicRecovery(svcLogger svc1log.Logger, evtLogger evt2log.Logger) wrouter.RequestHandlerMiddleware {
+func NewRequestPanicRecovery(
+	svcLogger svc1log.Logger,
+	evtLogger evt2log.Logger,
+	diagLogger diag1log.Logger,
+	registry metrics.Registry,
+	goroutineDumpOnPanic refreshable.Bool,
+) wrouter.RequestHandlerMiddleware {
 	return func(rw http.ResponseWriter, req *http.Request, next http.Handler) {
 		lrw := toLoggingResponseWriter(rw)
-		panicRecoveryMiddleware(lrw, req, svcLogger, evtLogger, func() {
+		panicRecoveryMiddleware(lrw, req, panicRecoveryParams{
+			svcLogger:            svcLogger,
+			evtLogger:            evtLogger,
+			diagLogger:           diagLogger,
+			registry:             registry,
+			goroutineDumpOnPanic: goroutineDumpOnPanic,
+		}, func() {
 			next.ServeHTTP(lrw, req)
 		})
 	}
@@ -191,3 +210,61 @@ func NewRequestMetricRequestMeter(mr metrics.RootRegistry) wrouter.RouteHandlerM
 		}
 	}
 }
+
+type clientCertContextKey struct{}
+
+// ClientCertFromContext returns the verified client certificate stored on the provided context by
+// NewRequestClientCert. Returns nil if the context does not contain a verified client certificate.
+func ClientCertFromContext(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(clientCertContextKey{}).(*x509.Certificate)
+	return cert
+}
+
+// NewRequestClientCert is request middleware that sets the verified certificate presented by the client on the request
+// context. If clientCAs is nil, the certificate is only set if it was verified during the TLS handshake. Otherwise,
+// clientCAs must provide a *x509.CertPool and any certificate presented by the client is verified against the current
+// pool: requests with certificates that fail verification are rejected with a 403 response, which is recorded using
+// reqLogger if it is non-nil, and are not passed to the next handler.
+func NewRequestClientCert(clientCAs refreshable.Refreshable, reqLogger req2log.Logger) wrouter.RequestHandlerMiddleware {
+	return func(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+		if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if clientCAs == nil {
+			if len(req.TLS.VerifiedChains) > 0 {
+				req = req.WithContext(context.WithValue(req.Context(), clientCertContextKey{}, req.TLS.PeerCertificates[0]))
+			}
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		start := time.Now()
+		clientCert := req.TLS.PeerCertificates[0]
+		intermediates := x509.NewCertPool()
+		for _, cert := range req.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := clientCert.Verify(x509.VerifyOptions{
+			Roots:         clientCAs.Current().(*x509.CertPool),
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			svc1log.FromContext(req.Context()).Warn("Rejecting request: client certificate verification failed",
+				svc1log.UnsafeParam("clientCertSubject", clientCert.Subject.String()),
+				svc1log.Stacktrace(err))
+			lrw := toLoggingResponseWriter(rw)
+			errors.WriteErrorResponse(lrw, errors.NewPermissionDenied())
+			if reqLogger != nil {
+				reqLogger.Request(req2log.Request{
+					Request:        req,
+					ResponseStatus: lrw.Status(),
+					ResponseSize:   int64(lrw.Size()),
+					Duration:       time.Since(start),
+				})
+			}
+			return
+		}
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), clientCertContextKey{}, clientCert)))
+	}
+}
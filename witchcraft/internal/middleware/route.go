@@ -15,16 +15,11 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
 	"time"
 
-	"github.com/palantir/conjure-go-runtime/v2/conjure-go-contract/errors"
-	"github.com/palantir/conjure-go-runtime/v2/conjure-go-server/httpserver"
-	"github.com/palantir/witchcraft-go-logging/wlog/evtlog/evt2log"
+	"github.com/palantir/pkg/refreshable"
 	"github.com/palantir/witchcraft-go-logging/wlog/reqlog/req2log"
-	"github.com/palantir/witchcraft-go-logging/wlog/svclog/svc1log"
-	"github.com/palantir/witchcraft-go-logging/wlog/wapp"
 	"github.com/palantir/witchcraft-go-server/v2/witchcraft/internal/negroni"
 	"github.com/palantir/witchcraft-go-server/v2/wrouter"
 	"github.com/palantir/witchcraft-go-tracing/wtracing"
@@ -128,34 +123,17 @@ func NewRouteLogTraceSpan() wrouter.RouteHandlerMiddleware {
 
 // NewRoutePanicRecovery returns a middleware which recovers panics within the inner route handler.
 // This is distinct from NewRequestPanicRecovery in that it runs when all logging/telemetry are configured on the request.
-func NewRoutePanicRecovery() wrouter.RouteHandlerMiddleware {
+// Panics are recorded in the "server.panics" metric of the registry on the request context, tagged by route. If
+// goroutineDumpOnPanic is non-nil and true, a dump of all goroutines is written to the diagnostic logger on the
+// request context whenever a panic is recovered.
+func NewRoutePanicRecovery(goroutineDumpOnPanic refreshable.Bool) wrouter.RouteHandlerMiddleware {
 	return func(rw http.ResponseWriter, req *http.Request, reqVals wrouter.RequestVals, next wrouter.RouteRequestHandler) {
 		lrw := toLoggingResponseWriter(rw)
-		panicRecoveryMiddleware(lrw, req, nil, nil, func() {
+		panicRecoveryMiddleware(lrw, req, panicRecoveryParams{
+			route:                reqVals.Spec.PathTemplate,
+			goroutineDumpOnPanic: goroutineDumpOnPanic,
+		}, func() {
 			next(lrw, req, reqVals)
 		})
 	}
 }
-
-func panicRecoveryMiddleware(lrw loggingResponseWriter, req *http.Request, svcLogger svc1log.Logger, evtLogger evt2log.Logger, nextFunc func()) {
-	ctx := req.Context() // ctx changes are used within this middleware but not stored to the request
-	if svcLogger != nil {
-		ctx = svc1log.WithLogger(ctx, svcLogger)
-	}
-	if evtLogger != nil {
-		ctx = evt2log.WithLogger(ctx, evtLogger)
-	}
-
-	if err := wapp.RunWithRecoveryLoggingWithError(ctx, func(ctx context.Context) error {
-		nextFunc()
-		return nil
-	}); err != nil {
-		cerr := errors.WrapWithInternal(err)
-		httpserver.ErrHandler(ctx, cerr.Code().StatusCode(), cerr)
-
-		// Only write to response if we have not written anything yet
-		if !lrw.Written() {
-			errors.WriteErrorResponse(lrw, cerr)
-		}
-	}
-}
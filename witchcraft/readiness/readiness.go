@@ -0,0 +1,106 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package readiness provides gates that hold back the readiness of a server until named warmup tasks have completed.
+package readiness
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	healthstatus "github.com/palantir/witchcraft-go-health/status"
+)
+
+// Gates is a set of named readiness gates. A server is not reported as ready while any gate created by NewGate is
+// pending. The zero value is ready to use.
+type Gates struct {
+	mutex   sync.Mutex
+	pending map[*Gate]struct{}
+}
+
+// Gate is a named readiness gate that is pending until Open is called.
+type Gate struct {
+	name  string
+	gates *Gates
+}
+
+// Status is the payload of the readiness endpoint when readiness is held back by pending gates.
+type Status struct {
+	// PendingGates contains the names of the gates that have not yet been opened in alphabetical order.
+	PendingGates []string `json:"pendingGates"`
+}
+
+// NewGate creates and returns a new pending gate with the provided name. The name is used to identify the gate in the
+// readiness status and does not need to be unique.
+func (g *Gates) NewGate(name string) *Gate {
+	gate := &Gate{
+		name:  name,
+		gates: g,
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.pending == nil {
+		g.pending = make(map[*Gate]struct{})
+	}
+	g.pending[gate] = struct{}{}
+	return gate
+}
+
+// Pending returns the names of the gates that have not yet been opened in alphabetical order.
+func (g *Gates) Pending() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	names := make([]string, 0, len(g.pending))
+	for gate := range g.pending {
+		names = append(names, gate.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Source returns a healthstatus.Source that reports http.StatusServiceUnavailable with a Status payload while any gate
+// is pending and otherwise reports the status of the provided source.
+func (g *Gates) Source(source healthstatus.Source) healthstatus.Source {
+	return &gatedSource{
+		gates:  g,
+		source: source,
+	}
+}
+
+// Name returns the name of the gate.
+func (g *Gate) Name() string {
+	return g.name
+}
+
+// Open marks the gate as complete. Calling Open on a gate that is already open has no effect.
+func (g *Gate) Open() {
+	g.gates.mutex.Lock()
+	defer g.gates.mutex.Unlock()
+	delete(g.gates.pending, g)
+}
+
+type gatedSource struct {
+	gates  *Gates
+	source healthstatus.Source
+}
+
+func (s *gatedSource) Status() (int, interface{}) {
+	if pending := s.gates.Pending(); len(pending) > 0 {
+		return http.StatusServiceUnavailable, Status{
+			PendingGates: pending,
+		}
+	}
+	return s.source.Status()
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type constantSource struct {
+	status   int
+	metadata interface{}
+}
+
+func (s constantSource) Status() (int, interface{}) {
+	return s.status, s.metadata
+}
+
+func TestGates(t *testing.T) {
+	var gates Gates
+	source := gates.Source(constantSource{status: http.StatusOK, metadata: "delegate"})
+
+	statusCode, metadata := source.Status()
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "delegate", metadata)
+
+	cacheGate := gates.NewGate("cache-warmup")
+	indexGate := gates.NewGate("index-warmup")
+	assert.Equal(t, "cache-warmup", cacheGate.Name())
+	assert.Equal(t, []string{"cache-warmup", "index-warmup"}, gates.Pending())
+
+	statusCode, metadata = source.Status()
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Equal(t, Status{PendingGates: []string{"cache-warmup", "index-warmup"}}, metadata)
+
+	indexGate.Open()
+	indexGate.Open()
+	statusCode, metadata = source.Status()
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Equal(t, Status{PendingGates: []string{"cache-warmup"}}, metadata)
+
+	cacheGate.Open()
+	assert.Empty(t, gates.Pending())
+	statusCode, metadata = source.Status()
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "delegate", metadata)
+}
+
+func TestGatesReportDelegateNotReady(t *testing.T) {
+	var gates Gates
+	gates.NewGate("warmup").Open()
+	statusCode, metadata := gates.Source(constantSource{status: http.StatusServiceUnavailable}).Status()
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Nil(t, metadata)
+}
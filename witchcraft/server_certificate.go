@@ -0,0 +1,180 @@
+// Copyright (c) 2021 Palantir Technologies. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package witchcraft
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+
+	"github.com/palantir/pkg/refreshable"
+	werror "github.com/palantir/witchcraft-go-error"
+	healthstatus "github.com/palantir/witchcraft-go-health/status"
+	"github.com/palantir/witchcraft-go-logging/wlog/svclog/svc1log"
+	"github.com/palantir/witchcraft-go-server/v2/config"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft/internal/middleware"
+	refreshablehealth "github.com/palantir/witchcraft-go-server/v2/witchcraft/internal/refreshable"
+	refreshablefile "github.com/palantir/witchcraft-go-server/v2/witchcraft/refreshable"
+)
+
+const (
+	serverCertificateReloadCheckType = "SERVER_CERTIFICATE_RELOAD"
+	clientCAReloadCheckType          = "CLIENT_CA_RELOAD"
+)
+
+// ClientCertFromContext returns the verified certificate presented by the client of the request associated with the
+// provided context. Returns nil if the client did not present a certificate, if the server is not configured to verify
+// client certificates or if the context is not a request context.
+func ClientCertFromContext(ctx context.Context) *x509.Certificate {
+	return middleware.ClientCertFromContext(ctx)
+}
+
+// clientAuthType returns the TLS client authentication type specified by the "client-auth-type" install configuration
+// value. If the value is empty, the client authentication type configured using WithClientAuth is returned.
+func (s *Server) clientAuthType(serverConfig config.Server) (tls.ClientAuthType, error) {
+	switch serverConfig.ClientAuthType {
+	case "":
+		return s.clientAuth, nil
+	case config.ClientAuthTypeNone:
+		return tls.NoClientCert, nil
+	case config.ClientAuthTypeRequest:
+		return tls.RequestClientCert, nil
+	case config.ClientAuthTypeRequireAndVerify:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, werror.Error("invalid client-auth-type in server configuration",
+			werror.SafeParam("clientAuthType", serverConfig.ClientAuthType),
+			werror.SafeParam("supportedClientAuthTypes", []string{config.ClientAuthTypeNone, config.ClientAuthTypeRequest, config.ClientAuthTypeRequireAndVerify}))
+	}
+}
+
+// deferredVerificationClientAuthType returns the client authentication type that should be used for TLS handshakes when
+// client certificates are verified by middleware against a refreshable pool of client CAs rather than during the
+// handshake. Verifying certificates in middleware allows the CA pool to be reloaded and allows requests with
+// certificates that fail verification to be recorded in the request log. The second return value is false if
+// clientAuthType does not verify client certificates.
+func deferredVerificationClientAuthType(clientAuthType tls.ClientAuthType) (tls.ClientAuthType, bool) {
+	switch clientAuthType {
+	case tls.RequireAndVerifyClientCert:
+		return tls.RequireAnyClientCert, true
+	case tls.VerifyClientCertIfGiven:
+		return tls.RequestClientCert, true
+	default:
+		return clientAuthType, false
+	}
+}
+
+// newRefreshableFiles returns a refreshable.Refreshable whose current value is a [][]byte that contains the contents of
+// the files at the provided paths (in the same order). The files are watched for changes until ctx is done.
+func newRefreshableFiles(ctx context.Context, filePaths []string) (refreshable.Refreshable, error) {
+	fileRefreshables := make([]refreshable.Refreshable, len(filePaths))
+	for i, filePath := range filePaths {
+		fileRefreshable, err := refreshablefile.NewFileRefreshable(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+		fileRefreshables[i] = fileRefreshable
+	}
+
+	currentFileBytes := func() [][]byte {
+		fileBytes := make([][]byte, len(fileRefreshables))
+		for i, fileRefreshable := range fileRefreshables {
+			fileBytes[i] = fileRefreshable.Current().([]byte)
+		}
+		return fileBytes
+	}
+	filesRefreshable := refreshable.NewDefaultRefreshable(currentFileBytes())
+	for _, fileRefreshable := range fileRefreshables {
+		fileRefreshable.Subscribe(func(interface{}) {
+			_ = filesRefreshable.Update(currentFileBytes())
+		})
+	}
+	return filesRefreshable, nil
+}
+
+// newRefreshableServerCertificate returns a refreshable.Refreshable whose current value is the *tls.Certificate
+// loaded from the provided certificate and key files. The files are watched for changes until ctx is done. A change
+// that results in an invalid key pair (for example, because only one of the files has been replaced so far) is rejected
+// and the previous certificate is retained: the returned health check source reports an error as long as the files
+// on disk do not form a valid key pair. Returns an error if the initial contents of the files are not a valid key pair.
+func newRefreshableServerCertificate(ctx context.Context, certFile, keyFile string) (refreshable.Refreshable, healthstatus.HealthCheckSource, error) {
+	keyPairRefreshable, err := newRefreshableFiles(ctx, []string{certFile, keyFile})
+	if err != nil {
+		return nil, nil, werror.WrapWithContextParams(ctx, err, "failed to load server certificate and key files")
+	}
+
+	validatedCert, err := refreshable.NewMapValidatingRefreshable(keyPairRefreshable, func(keyPairVal interface{}) (interface{}, error) {
+		keyPair := keyPairVal.([][]byte)
+		cert, err := tls.X509KeyPair(keyPair[0], keyPair[1])
+		if err != nil {
+			return nil, werror.WrapWithContextParams(ctx, err, "server certificate and key files do not contain a valid key pair",
+				werror.SafeParam("certFile", certFile),
+				werror.SafeParam("keyFile", keyFile))
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, werror.WrapWithContextParams(ctx, err, "failed to parse server certificate", werror.SafeParam("certFile", certFile))
+		}
+		cert.Leaf = leaf
+		return &cert, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logServerCertificate := func(certVal interface{}) {
+		leaf := certVal.(*tls.Certificate).Leaf
+		fingerprint := sha256.Sum256(leaf.Raw)
+		svc1log.FromContext(ctx).Info("Loaded server certificate",
+			svc1log.SafeParam("certFile", certFile),
+			svc1log.SafeParam("notAfter", leaf.NotAfter.UTC().Format(time.RFC3339)),
+			svc1log.SafeParam("sha256Fingerprint", hex.EncodeToString(fingerprint[:])))
+	}
+	logServerCertificate(validatedCert.Current())
+	validatedCert.Subscribe(logServerCertificate)
+
+	return validatedCert, refreshablehealth.NewValidatingRefreshableHealthCheckSource(serverCertificateReloadCheckType, *validatedCert), nil
+}
+
+// newRefreshableClientCAs returns a refreshable.Refreshable whose current value is the *x509.CertPool that contains the
+// certificates in the provided CA files. The files are watched for changes until ctx is done. A change that results in a
+// file that does not contain any certificates is rejected and the previous pool is retained: the returned health check
+// source reports an error until the files are valid again. Returns an error if the initial contents of the files are
+// not valid.
+func newRefreshableClientCAs(ctx context.Context, caFiles []string) (refreshable.Refreshable, healthstatus.HealthCheckSource, error) {
+	caFilesRefreshable, err := newRefreshableFiles(ctx, caFiles)
+	if err != nil {
+		return nil, nil, werror.WrapWithContextParams(ctx, err, "failed to load client CA files")
+	}
+	validatedCAs, err := refreshable.NewMapValidatingRefreshable(caFilesRefreshable, func(caFilesVal interface{}) (interface{}, error) {
+		certPool := x509.NewCertPool()
+		for i, caFileBytes := range caFilesVal.([][]byte) {
+			if ok := certPool.AppendCertsFromPEM(caFileBytes); !ok {
+				return nil, werror.ErrorWithContextParams(ctx, "no certificates detected in client CA file", werror.SafeParam("caFile", caFiles[i]))
+			}
+		}
+		return certPool, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	validatedCAs.Subscribe(func(interface{}) {
+		svc1log.FromContext(ctx).Info("Loaded client CA certificates", svc1log.SafeParam("caFiles", caFiles))
+	})
+	return validatedCAs, refreshablehealth.NewValidatingRefreshableHealthCheckSource(clientCAReloadCheckType, *validatedCAs), nil
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/palantir/witchcraft-go-server/v2/status/routes"
 	"github.com/palantir/witchcraft-go-server/v2/witchcraft/internal/middleware"
 	"github.com/palantir/witchcraft-go-server/v2/witchcraft/internal/wdebug"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft/readiness"
 	"github.com/palantir/witchcraft-go-server/v2/witchcraft/wresource"
 	"github.com/palantir/witchcraft-go-server/v2/wrouter"
 	"github.com/palantir/witchcraft-go-tracing/wtracing"
@@ -44,7 +45,7 @@ func (s *Server) initRouters(installCfg config.Install) (rRouter wrouter.Router,
 	return routerWithContextPath, mgmtRouterWithContextPath
 }
 
-func (s *Server) addRoutes(mgmtRouterWithContextPath wrouter.Router, runtimeCfg refreshableBaseRuntimeConfig) error {
+func (s *Server) addRoutes(mgmtRouterWithContextPath wrouter.Router, runtimeCfg refreshableBaseRuntimeConfig, readinessGates *readiness.Gates) error {
 	// add debugging endpoints to management router
 	if err := addPprofRoutes(mgmtRouterWithContextPath); err != nil {
 		return werror.Wrap(err, "failed to register debugging routes")
@@ -72,20 +73,29 @@ func (s *Server) addRoutes(mgmtRouterWithContextPath wrouter.Router, runtimeCfg
 		return werror.Wrap(err, "failed to register liveness routes")
 	}
 
-	// add readiness endpoints
+	// add readiness endpoints. The server is not ready while any readiness gate registered by initFn is pending.
 	if s.readinessSource == nil {
 		s.readinessSource = &s.stateManager
 	}
-	if err := routes.AddReadinessRoutes(statusResource, s.readinessSource); err != nil {
+	if err := routes.AddReadinessRoutes(statusResource, readinessGates.Source(s.readinessSource)); err != nil {
 		return werror.Wrap(err, "failed to register readiness routes")
 	}
 	return nil
 }
 
-func (s *Server) addMiddleware(rootRouter wrouter.RootRouter, registry metrics.RootRegistry, tracerOptions []wtracing.TracerOption) {
+func (s *Server) addMiddleware(rootRouter wrouter.RootRouter, registry metrics.RootRegistry, tracerOptions []wtracing.TracerOption, runtimeCfg refreshableBaseRuntimeConfig, clientCAs refreshable.Refreshable) {
+	// requests rejected by the client certificate middleware never reach the route middleware, so they are written to
+	// the request log directly unless the request log middleware has been disabled or replaced
+	clientCertReqLogger := s.reqLogger
+	if _, replaced := s.defaultMiddlewareOverrides[DefaultMiddlewareRequestLog]; replaced {
+		clientCertReqLogger = nil
+	}
+	goroutineDumpOnPanic := refreshable.NewBool(runtimeCfg.Map(func(in interface{}) interface{} {
+		return in.(config.Runtime).DiagnosticsConfig.GoroutineDumpOnPanic
+	}))
 	rootRouter.AddRequestHandlerMiddleware(
 		// add middleware that recovers from panics in request middleware
-		middleware.NewRequestPanicRecovery(s.svcLogger, s.evtLogger),
+		middleware.NewRequestPanicRecovery(s.svcLogger, s.evtLogger, s.diagLogger, registry, goroutineDumpOnPanic),
 		// add middleware that injects metrics registry into request context
 		middleware.NewRequestContextMetricsRegistry(registry),
 		// add middleware that injects loggers into request context
@@ -104,20 +114,23 @@ func (s *Server) addMiddleware(rootRouter wrouter.RootRouter, registry metrics.R
 			tracerOptions,
 			s.idsExtractor,
 		),
+		// add middleware that verifies client certificates against clientCAs (if non-nil) and sets the verified client
+		// certificate on the request context
+		middleware.NewRequestClientCert(clientCAs, clientCertReqLogger),
 	)
 
 	// add middleware that records HTTP request stats as metrics in registry
-	rootRouter.AddRouteHandlerMiddleware(middleware.NewRequestMetricRequestMeter(registry))
+	s.addDefaultRouteMiddleware(rootRouter, DefaultMiddlewareRequestMetrics, middleware.NewRequestMetricRequestMeter(registry))
 
 	// add user-provided middleware
 	rootRouter.AddRequestHandlerMiddleware(s.handlers...)
 
 	// add route middleware
-	rootRouter.AddRouteHandlerMiddleware(middleware.NewRouteRequestLog(s.reqLogger, nil))
-	rootRouter.AddRouteHandlerMiddleware(middleware.NewRouteLogTraceSpan())
+	s.addDefaultRouteMiddleware(rootRouter, DefaultMiddlewareRequestLog, middleware.NewRouteRequestLog(s.reqLogger, nil))
+	s.addDefaultRouteMiddleware(rootRouter, DefaultMiddlewareTrace, middleware.NewRouteLogTraceSpan())
 
 	// add a second, inner panic recovery middleware so panics within handler logic are correctly configured with logging, trace IDs, etc.
-	rootRouter.AddRouteHandlerMiddleware(middleware.NewRoutePanicRecovery())
+	s.addDefaultRouteMiddleware(rootRouter, DefaultMiddlewarePanicRecovery, middleware.NewRoutePanicRecovery(goroutineDumpOnPanic))
 
 	// add not found handler
 	rootRouter.RegisterNotFoundHandler(httpserver.NewJSONHandler(
@@ -23,63 +23,129 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/palantir/pkg/refreshable"
 	"github.com/palantir/pkg/tlsconfig"
 	werror "github.com/palantir/witchcraft-go-error"
 	"github.com/palantir/witchcraft-go-logging/wlog/svclog/svc1log"
+	"github.com/palantir/witchcraft-go-logging/wlog/wapp"
 	"github.com/palantir/witchcraft-go-server/v2/config"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-func (s *Server) newServer(productName string, serverConfig config.Server, handler http.Handler) (rHTTPServer *http.Server, rStart func() error, rShutdown func(context.Context) error, rErr error) {
+func (s *Server) newServer(productName string, serverConfig config.Server, serverCert refreshable.Refreshable, clientAuth tls.ClientAuthType, handler http.Handler) (rHTTPServer *trackedHTTPServer, rListener net.Listener, rStart func() error, rShutdown func(context.Context) error, rErr error) {
 	return newServerStartShutdownFns(
 		serverConfig,
 		s.useSelfSignedServerCertificate,
-		s.clientAuth,
+		serverCert,
+		clientAuth,
 		productName,
 		s.svcLogger,
 		handler,
 	)
 }
 
-func (s *Server) newMgmtServer(productName string, serverConfig config.Server, handler http.Handler) (rStart func() error, rShutdown func(context.Context) error, rErr error) {
+func (s *Server) newMgmtServer(productName string, serverConfig config.Server, serverCert refreshable.Refreshable, handler http.Handler) (rListener net.Listener, rStart func() error, rShutdown func(context.Context) error, rErr error) {
 	serverConfig.Port = serverConfig.ManagementPort
-	_, start, shutdown, err := newServerStartShutdownFns(
+	// the management server always serves HTTPS on a TCP port
+	serverConfig.Listen = ""
+	serverConfig.H2C = false
+	_, listener, start, shutdown, err := newServerStartShutdownFns(
 		serverConfig,
 		s.useSelfSignedServerCertificate,
+		serverCert,
 		tls.NoClientCert,
 		productName+"-management",
 		s.svcLogger,
 		handler,
 	)
-	return start, shutdown, err
+	return listener, start, shutdown, err
 }
 
+// newServerStartShutdownFns creates the http.Server for the provided configuration and binds its listener. The returned
+// start function serves HTTPS traffic (or cleartext HTTP/1.1 and h2c traffic if serverConfig.H2C is true) on the
+// listener and blocks until the server is closed or shut down. If the start function is never called, the caller is
+// responsible for closing the returned listener. If serverCert is non-nil, the server presents its current
+// *tls.Certificate value for every new TLS connection.
 func newServerStartShutdownFns(
 	serverConfig config.Server,
 	useSelfSignedServerCertificate bool,
+	serverCert refreshable.Refreshable,
 	clientAuthType tls.ClientAuthType,
 	serverName string,
 	svcLogger svc1log.Logger,
 	handler http.Handler,
-) (rHTTPServer *http.Server, start func() error, shutdown func(context.Context) error, rErr error) {
-	tlsConfig, err := newTLSConfig(serverConfig, useSelfSignedServerCertificate, clientAuthType)
+) (rHTTPServer *trackedHTTPServer, rListener net.Listener, start func() error, shutdown func(context.Context) error, rErr error) {
+	listener, addr, err := newListener(serverConfig)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, werror.Wrap(err, "server failed to listen", werror.SafeParam("serverName", serverName), werror.SafeParam("address", addr))
 	}
 
-	addr := fmt.Sprintf("%v:%d", serverConfig.Address, serverConfig.Port)
 	httpServer := &http.Server{
-		Addr:      addr,
-		TLSConfig: tlsConfig,
-		Handler:   handler,
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: serverConfig.Timeouts.ReadHeaderTimeout,
+		ReadTimeout:       serverConfig.Timeouts.ReadTimeout,
+		WriteTimeout:      serverConfig.Timeouts.WriteTimeout,
+		IdleTimeout:       serverConfig.Timeouts.IdleTimeout,
+		MaxHeaderBytes:    serverConfig.Timeouts.MaxHeaderBytes,
+	}
+	http2Server := &http2.Server{
+		MaxConcurrentStreams:         serverConfig.HTTP2.MaxConcurrentStreams,
+		MaxUploadBufferPerStream:     serverConfig.HTTP2.InitialStreamWindowSize,
+		MaxUploadBufferPerConnection: serverConfig.HTTP2.InitialConnWindowSize,
 	}
-	return httpServer, func() error {
-		svcLogger.Info("Listening to https", svc1log.SafeParam("address", addr), svc1log.SafeParam("server", serverName))
 
+	protocol := "https"
+	serveFn := func() error {
 		// cert and key specified in TLS config so no need to pass in here
-		if err := httpServer.ListenAndServeTLS("", ""); err != nil {
+		return httpServer.ServeTLS(listener, "", "")
+	}
+	trackedServer := &trackedHTTPServer{
+		Server: httpServer,
+	}
+	if serverConfig.H2C {
+		protocol = "http"
+		// configuring the HTTP/2 server registers it to send GOAWAY frames to h2c connections on shutdown
+		if err := http2.ConfigureServer(httpServer, http2Server); err != nil {
+			_ = listener.Close()
+			return nil, nil, nil, nil, werror.Wrap(err, "failed to configure HTTP/2 for server", werror.SafeParam("serverName", serverName))
+		}
+		trackedServer.hijackedConns = &hijackedConns{}
+		httpServer.Handler = trackedServer.hijackedConns.handler(h2c.NewHandler(handler, http2Server))
+		httpServer.ConnState = trackedServer.hijackedConns.connState
+		httpServer.ConnContext = trackedServer.hijackedConns.connContext
+		serveFn = func() error {
+			return httpServer.Serve(listener)
+		}
+	} else {
+		// TLS configuration is created once the listener is bound so that a self-signed certificate can include the
+		// address to which the server is actually bound
+		tlsConfig, err := newTLSConfig(serverConfig, listener.Addr(), useSelfSignedServerCertificate, serverCert, clientAuthType)
+		if err != nil {
+			_ = listener.Close()
+			return nil, nil, nil, nil, err
+		}
+		httpServer.TLSConfig = tlsConfig
+		// the HTTP/2 support built into net/http is only replaced if HTTP/2 settings are configured
+		if serverConfig.HTTP2 != (config.HTTP2{}) {
+			if err := http2.ConfigureServer(httpServer, http2Server); err != nil {
+				_ = listener.Close()
+				return nil, nil, nil, nil, werror.Wrap(err, "failed to configure HTTP/2 for server", werror.SafeParam("serverName", serverName))
+			}
+		}
+	}
+	return trackedServer, listener, func() error {
+		svcLogger.Info("Listening to "+protocol, svc1log.SafeParam("address", listener.Addr().String()), svc1log.SafeParam("server", serverName))
+
+		if err := serveFn(); err != nil {
 			if err == http.ErrServerClosed {
 				svcLogger.Info(fmt.Sprintf("%s was closed", serverName))
 				return nil
@@ -87,10 +153,264 @@ func newServerStartShutdownFns(
 			return werror.Wrap(err, "server failed", werror.SafeParam("serverName", serverName))
 		}
 		return nil
-	}, httpServer.Shutdown, nil
+	}, trackedServer.Shutdown, nil
+}
+
+// trackedHTTPServer is an *http.Server whose Shutdown and Close methods also apply to the connections that were
+// hijacked to serve h2c. The http.Server stops tracking connections once they are hijacked, so without this it would
+// neither wait for in-flight h2c streams to complete on shutdown nor close h2c connections when it is closed.
+type trackedHTTPServer struct {
+	*http.Server
+
+	// nil if the server does not serve h2c
+	hijackedConns *hijackedConns
+}
+
+// Shutdown gracefully shuts down the server and then waits for all hijacked connections to be closed or for ctx to be
+// done, whichever comes first.
+func (s *trackedHTTPServer) Shutdown(ctx context.Context) error {
+	if err := s.Server.Shutdown(ctx); err != nil {
+		return err
+	}
+	return s.hijackedConns.wait(ctx)
+}
+
+// Close immediately closes the server along with all hijacked connections.
+func (s *trackedHTTPServer) Close() error {
+	err := s.Server.Close()
+	s.hijackedConns.closeAll()
+	return err
+}
+
+type hijackedConnContextKey struct{}
+
+// hijackedConns tracks the connections of an http.Server that are hijacked by its handler. A connection is tracked from
+// the time that it is hijacked until the handler invocation that hijacked it returns, which is when the h2c handler has
+// finished serving the connection. The zero value is ready to use. All methods are no-ops on a nil *hijackedConns.
+type hijackedConns struct {
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// connContext is the http.Server.ConnContext hook that stores the connection on the context of its requests.
+func (h *hijackedConns) connContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, hijackedConnContextKey{}, conn)
+}
+
+// connState is the http.Server.ConnState hook that starts tracking connections when they are hijacked.
+func (h *hijackedConns) connState(conn net.Conn, state http.ConnState) {
+	if state != http.StateHijacked {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.conns == nil {
+		h.conns = make(map[net.Conn]struct{})
+	}
+	h.conns[conn] = struct{}{}
+}
+
+// handler returns a handler that stops tracking the connection of a request once the provided handler has returned.
+func (h *hijackedConns) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if conn, ok := req.Context().Value(hijackedConnContextKey{}).(net.Conn); ok {
+				h.mutex.Lock()
+				defer h.mutex.Unlock()
+				delete(h.conns, conn)
+			}
+		}()
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// wait blocks until no hijacked connections are tracked or until ctx is done, in which case the context error is
+// returned. The tracked connections are polled in the same manner as http.Server.Shutdown polls for idle connections.
+func (h *hijackedConns) wait(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	ticker := time.NewTicker(hijackedConnsPollInterval)
+	defer ticker.Stop()
+	for {
+		h.mutex.Lock()
+		remaining := len(h.conns)
+		h.mutex.Unlock()
+		if remaining == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeAll closes all tracked connections.
+func (h *hijackedConns) closeAll() {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for conn := range h.conns {
+		_ = conn.Close()
+	}
+}
+
+const hijackedConnsPollInterval = 50 * time.Millisecond
+
+// validateTimeouts returns an error if the shutdown timeout or any of the values in the timeouts configuration of the
+// provided server configuration is negative.
+func validateTimeouts(serverConfig config.Server) error {
+	timeouts := serverConfig.Timeouts
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{name: "read-header-timeout", value: timeouts.ReadHeaderTimeout},
+		{name: "read-timeout", value: timeouts.ReadTimeout},
+		{name: "write-timeout", value: timeouts.WriteTimeout},
+		{name: "idle-timeout", value: timeouts.IdleTimeout},
+		{name: "shutdown-timeout", value: serverConfig.ShutdownTimeout},
+	} {
+		if timeout.value < 0 {
+			return werror.Error("server timeout must not be negative", werror.SafeParam("timeout", timeout.name), werror.SafeParam("value", timeout.value.String()))
+		}
+	}
+	if timeouts.MaxHeaderBytes < 0 {
+		return werror.Error("server max-header-bytes must not be negative", werror.SafeParam("value", timeouts.MaxHeaderBytes))
+	}
+	return nil
 }
 
-func newTLSConfig(serverConfig config.Server, useSelfSignedServerCertificate bool, clientAuthType tls.ClientAuthType) (*tls.Config, error) {
+// validateHTTP2 returns an error if any of the window sizes in the provided HTTP/2 configuration is negative.
+func validateHTTP2(http2Config config.HTTP2) error {
+	if http2Config.InitialStreamWindowSize < 0 {
+		return werror.Error("server HTTP/2 initial-stream-window-size must not be negative", werror.SafeParam("value", http2Config.InitialStreamWindowSize))
+	}
+	if http2Config.InitialConnWindowSize < 0 {
+		return werror.Error("server HTTP/2 initial-connection-window-size must not be negative", werror.SafeParam("value", http2Config.InitialConnWindowSize))
+	}
+	return nil
+}
+
+// validateH2C returns an error if serverConfig enables h2c along with client certificate authentication. Connections
+// served using h2c do not use TLS, so client certificates cannot be requested or verified.
+func validateH2C(serverConfig config.Server, clientAuthType tls.ClientAuthType) error {
+	if !serverConfig.H2C {
+		return nil
+	}
+	if clientAuthType != tls.NoClientCert {
+		return werror.Error("server h2c cannot be combined with client certificate authentication because h2c does not use TLS",
+			werror.SafeParam("clientAuthType", clientAuthType.String()))
+	}
+	if len(serverConfig.ClientCAFiles) > 0 {
+		return werror.Error("server h2c cannot be combined with client-ca-files because h2c does not use TLS",
+			werror.SafeParam("clientCAFiles", serverConfig.ClientCAFiles))
+	}
+	return nil
+}
+
+const (
+	unixSocketListenPrefix = "unix://"
+	defaultSocketFileMode  = os.FileMode(0660)
+)
+
+// newListener returns a listener bound to the address specified by the provided configuration along with a string
+// representation of that address. If serverConfig.Listen specifies a Unix domain socket, a stale socket at its path is
+// removed before binding and the socket file mode is set once it is created. Closing the returned listener removes the
+// socket file. Otherwise, the listener is bound to the TCP address specified by serverConfig.Address and
+// serverConfig.Port.
+func newListener(serverConfig config.Server) (net.Listener, string, error) {
+	if serverConfig.Listen == "" {
+		addr := fmt.Sprintf("%v:%d", serverConfig.Address, serverConfig.Port)
+		listener, err := net.Listen("tcp", addr)
+		return listener, addr, err
+	}
+	if !strings.HasPrefix(serverConfig.Listen, unixSocketListenPrefix) {
+		return nil, serverConfig.Listen, werror.Error("listen value must be a URI of the form unix://<path>")
+	}
+	socketPath := strings.TrimPrefix(serverConfig.Listen, unixSocketListenPrefix)
+	if socketPath == "" {
+		return nil, serverConfig.Listen, werror.Error("listen value does not specify a socket path")
+	}
+
+	// remove socket left behind by a previous process that did not stop cleanly, but never remove other files
+	if fi, err := os.Lstat(socketPath); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, socketPath, werror.Error("file at socket path exists and is not a socket")
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, socketPath, werror.Wrap(err, "failed to remove stale socket")
+		}
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, socketPath, err
+	}
+	fileMode := serverConfig.SocketFileMode
+	if fileMode == 0 {
+		fileMode = defaultSocketFileMode
+	}
+	if err := os.Chmod(socketPath, fileMode); err != nil {
+		_ = listener.Close()
+		return nil, socketPath, werror.Wrap(err, "failed to set socket file mode")
+	}
+	return listener, socketPath, nil
+}
+
+// runOnStartedHooks runs the registered on-started hooks in order and returns the error of the first hook that fails.
+// The remaining hooks are skipped once svrStopped is closed so that they do not announce a server that is not serving.
+func (s *Server) runOnStartedHooks(ctx context.Context, info ServerStartInfo, svrStopped <-chan struct{}) error {
+	for i, hook := range s.onStartedHooks {
+		select {
+		case <-svrStopped:
+			return nil
+		default:
+		}
+		if err := hook(ctx, info); err != nil {
+			return werror.WrapWithContextParams(ctx, err, "server on-started hook failed", werror.SafeParam("hookIndex", i))
+		}
+	}
+	return nil
+}
+
+// runOnShutdownHooks runs the registered on-shutdown hooks in order. A failing hook is logged and does not prevent the
+// remaining hooks from running or the server from shutting down. The hooks are abandoned if they do not complete before
+// the shutdown timeout elapses or ctx is done.
+func (s *Server) runOnShutdownHooks(ctx context.Context) {
+	if len(s.onShutdownHooks) == 0 {
+		return
+	}
+	timeout := s.shutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go wapp.RunWithRecoveryLogging(ctx, func(ctx context.Context) {
+		defer close(done)
+		for i, hook := range s.onShutdownHooks {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := hook(ctx); err != nil {
+				s.svcLogger.Warn("Server on-shutdown hook failed", svc1log.SafeParam("hookIndex", i), svc1log.Stacktrace(err))
+			}
+		}
+	})
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.svcLogger.Warn("Server on-shutdown hooks did not complete before the shutdown timeout", svc1log.SafeParam("shutdownTimeout", timeout.String()))
+	}
+}
+
+func newTLSConfig(serverConfig config.Server, listenerAddr net.Addr, useSelfSignedServerCertificate bool, serverCert refreshable.Refreshable, clientAuthType tls.ClientAuthType) (*tls.Config, error) {
 	if !useSelfSignedServerCertificate && (serverConfig.KeyFile == "" || serverConfig.CertFile == "") {
 		var msg string
 		if serverConfig.KeyFile == "" && serverConfig.CertFile == "" {
@@ -104,7 +424,7 @@ func newTLSConfig(serverConfig config.Server, useSelfSignedServerCertificate boo
 	}
 
 	tlsConfig, err := tlsconfig.NewServerConfig(
-		newTLSCertProvider(useSelfSignedServerCertificate, serverConfig.CertFile, serverConfig.KeyFile),
+		newTLSCertProvider(useSelfSignedServerCertificate, serverConfig, listenerAddr, serverCert),
 		tlsconfig.ServerClientCAFiles(serverConfig.ClientCAFiles...),
 		tlsconfig.ServerClientAuthType(clientAuthType),
 		tlsconfig.ServerNextProtos("h2"),
@@ -112,22 +432,51 @@ func newTLSConfig(serverConfig config.Server, useSelfSignedServerCertificate boo
 	if err != nil {
 		return nil, werror.Wrap(err, "failed to initialize TLS configuration for server")
 	}
+	if !useSelfSignedServerCertificate && serverCert != nil {
+		// resolve the certificate for every handshake so that certificates that are rotated on disk are served
+		// without restarting the server
+		tlsConfig.Certificates = nil
+		tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return serverCert.Current().(*tls.Certificate), nil
+		}
+	}
 	return tlsConfig, nil
 }
 
-func newTLSCertProvider(useSelfSignedServerCertificate bool, certFile, keyFile string) tlsconfig.TLSCertProvider {
+func newTLSCertProvider(useSelfSignedServerCertificate bool, serverConfig config.Server, listenerAddr net.Addr, serverCert refreshable.Refreshable) tlsconfig.TLSCertProvider {
 	if useSelfSignedServerCertificate {
 		return func() (tls.Certificate, error) {
-			return newSelfSignedCertificate()
+			ipAddresses, dnsNames := selfSignedCertificateSANs(serverConfig.Address, listenerAddr)
+			return newSelfSignedCertificate(ipAddresses, dnsNames)
 		}
 	}
-	return tlsconfig.TLSCertFromFiles(certFile, keyFile)
+	if serverCert != nil {
+		return func() (tls.Certificate, error) {
+			return *serverCert.Current().(*tls.Certificate), nil
+		}
+	}
+	return tlsconfig.TLSCertFromFiles(serverConfig.CertFile, serverConfig.KeyFile)
+}
+
+// selfSignedCertificateSANs returns the IP and DNS SANs for a self-signed certificate for a server configured with the
+// provided address and bound to listenerAddr. The bound IP is included unless it is unspecified (for example, "0.0.0.0")
+// and the configured address is included as a DNS name if it is a host name. Ports cannot be expressed in SANs.
+func selfSignedCertificateSANs(configuredAddress string, listenerAddr net.Addr) ([]net.IP, []string) {
+	var ipAddresses []net.IP
+	if tcpAddr, ok := listenerAddr.(*net.TCPAddr); ok && !tcpAddr.IP.IsUnspecified() {
+		ipAddresses = append(ipAddresses, tcpAddr.IP)
+	}
+	var dnsNames []string
+	if configuredAddress != "" && net.ParseIP(configuredAddress) == nil {
+		dnsNames = append(dnsNames, configuredAddress)
+	}
+	return ipAddresses, dnsNames
 }
 
 // newSelfSignedCertificate creates a new self-signed certificate that can be used for TLS. The generated certificate is
-// quite minimal: it has a hard-coded serial number, is valid for 1 year and does NOT have a common name or IP SANs
-// set.
-func newSelfSignedCertificate() (tls.Certificate, error) {
+// quite minimal: it has a hard-coded serial number, is valid for 1 year, does NOT have a common name set and only has
+// the provided IP and DNS SANs.
+func newSelfSignedCertificate(ipAddresses []net.IP, dnsNames []string) (tls.Certificate, error) {
 	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		return tls.Certificate{}, err
@@ -140,6 +489,8 @@ func newSelfSignedCertificate() (tls.Certificate, error) {
 		SerialNumber: big.NewInt(1),
 		NotBefore:    time.Now().Add(-30 * time.Second),
 		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		IPAddresses:  ipAddresses,
+		DNSNames:     dnsNames,
 	}
 	certDERBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
 	if err != nil {
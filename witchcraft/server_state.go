@@ -18,6 +18,7 @@ import (
 	"context"
 	"net/http"
 	"strconv"
+	"sync"
 	"sync/atomic"
 
 	werror "github.com/palantir/witchcraft-go-error"
@@ -103,3 +104,60 @@ func (s *serverStateManager) HealthStatus(ctx context.Context) health.HealthStat
 		},
 	}
 }
+
+// serverAddrs records the addresses to which the listeners of a server are bound and allows callers to wait until they
+// are known. The zero value is ready to use.
+type serverAddrs struct {
+	mutex    sync.Mutex
+	ready    chan struct{}
+	resolved bool
+	addr     string
+	mgmtAddr string
+	err      error
+}
+
+// readyChan returns the channel that is closed once the addresses are resolved. Must be called with the mutex held.
+func (a *serverAddrs) readyChan() chan struct{} {
+	if a.ready == nil {
+		a.ready = make(chan struct{})
+	}
+	return a.ready
+}
+
+// reset clears previously resolved addresses so that callers wait for the listeners of the next start. Callers that are
+// already waiting on unresolved addresses continue to wait.
+func (a *serverAddrs) reset() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.resolved {
+		a.ready = nil
+		a.resolved = false
+		a.addr, a.mgmtAddr, a.err = "", "", nil
+	}
+}
+
+// resolve records the bound addresses or the error that prevented the listeners from being bound and unblocks waiting
+// callers. Has no effect if the addresses have already been resolved.
+func (a *serverAddrs) resolve(addr, mgmtAddr string, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.resolved {
+		return
+	}
+	a.addr, a.mgmtAddr, a.err = addr, mgmtAddr, err
+	a.resolved = true
+	close(a.readyChan())
+}
+
+// wait blocks until the addresses are resolved and returns them.
+func (a *serverAddrs) wait() (addr string, mgmtAddr string, err error) {
+	a.mutex.Lock()
+	ready := a.readyChan()
+	a.mutex.Unlock()
+
+	<-ready
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.addr, a.mgmtAddr, a.err
+}
@@ -21,7 +21,6 @@ import (
 	"io/ioutil"
 	"math"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
 	"reflect"
@@ -53,6 +52,7 @@ import (
 	"github.com/palantir/witchcraft-go-server/v2/config"
 	"github.com/palantir/witchcraft-go-server/v2/status"
 	refreshablehealth "github.com/palantir/witchcraft-go-server/v2/witchcraft/internal/refreshable"
+	"github.com/palantir/witchcraft-go-server/v2/witchcraft/readiness"
 	refreshablefile "github.com/palantir/witchcraft-go-server/v2/witchcraft/refreshable"
 	"github.com/palantir/witchcraft-go-server/v2/wrouter"
 	"github.com/palantir/witchcraft-go-server/v2/wrouter/whttprouter"
@@ -70,6 +70,10 @@ type Server struct {
 	// will have the appropriate loggers and logger parameters set.
 	handlers []wrouter.RequestHandlerMiddleware
 
+	// defaultMiddlewareOverrides specifies the built-in route middleware that have been replaced. A nil value indicates
+	// that the built-in middleware is disabled.
+	defaultMiddlewareOverrides map[DefaultMiddlewareName]wrouter.RouteHandlerMiddleware
+
 	// useSelfSignedServerCertificate specifies whether the server uses a dynamically generated self-signed certificate
 	// for TLS. No verification mechanism is provided for the self-signed certificate, so clients can only connect to a
 	// server using this mode in an untrusted manner. As such, this option should only be used in very specialized
@@ -83,6 +87,9 @@ type Server struct {
 	// manages storing and retrieving server state (idle, initializing, running)
 	stateManager serverStateManager
 
+	// records the addresses to which the server's listeners are bound once it has started
+	addrs serverAddrs
+
 	// specifies the io.Writer to which goroutine dump will be written if a SIGQUIT is received while the server is
 	// running. If nil, os.Stdout is used as the default. If the value is ioutil.Discard, then no plaintext output will
 	// be emitted. A diagnostic.1 line is logged unless disableSigQuitHandler is true.
@@ -94,6 +101,10 @@ type Server struct {
 	// if true, disables the default behavior of shutting down the server on SIGTERM and SIGINT signals.
 	disableShutdownSignalHandler bool
 
+	// shutdownTimeout bounds the graceful shutdown of the server: refer to config.Server.ShutdownTimeout. Set from the
+	// install configuration on start; if the configured value is 0, defaultShutdownTimeout is used.
+	shutdownTimeout time.Duration
+
 	// provides the bytes for the install configuration for the server. If nil, a default configuration provider that
 	// reads the file at "var/conf/install.yml" is used.
 	installConfigProvider ConfigBytesProvider
@@ -129,6 +140,14 @@ type Server struct {
 	// If this function returns an error, the server is not started and the error is returned.
 	initFn InitFunc
 
+	// onStartedHooks are called in order once the server's listeners are bound and it has started serving. If a hook
+	// returns an error, the remaining hooks are not run, the server is closed and Start returns the error.
+	onStartedHooks []OnStartedFunc
+
+	// onShutdownHooks are called in order when the server is shut down using Shutdown, before in-flight requests are
+	// drained. Their combined execution is bounded by the shutdown timeout.
+	onShutdownHooks []OnShutdownFunc
+
 	// installConfigStruct is a concrete struct used to determine the type into which the install configuration bytes
 	// are unmarshaled. If nil, a default value of config.Install{} is used.
 	installConfigStruct interface{}
@@ -208,8 +227,12 @@ type Server struct {
 	// nil if not enabled
 	asyncLogWriter tcpjson.AsyncWriter
 
-	// the http.Server for the main server
-	httpServer *http.Server
+	// the http.Server for the main server and the deadline of the most recent call to Shutdown (zero if Shutdown has not
+	// been called or was called with a context without a deadline). Guarded by httpServerMutex because they are read by
+	// the shutdown signal handler and by Close and Shutdown, which may run concurrently with Start.
+	httpServer       *trackedHTTPServer
+	shutdownDeadline time.Time
+	httpServerMutex  sync.Mutex
 
 	// allows the server to wait until Close() or Shutdown() return prior to returning from Start()
 	shutdownFinished sync.WaitGroup
@@ -241,6 +264,31 @@ type InitInfo struct {
 	// When the InitFunc is executed, the server is not yet started. This will most often be useful if launching a goroutine which
 	// requires access to shutdown the server in some error condition.
 	ShutdownServer func(context.Context) error
+
+	// ReadinessGates can be used to hold back the readiness of the server until warmup tasks that continue after the
+	// InitFunc returns have completed. The server is not reported as ready while any gate created using
+	// ReadinessGates.NewGate has not been opened, and the readiness endpoint lists the names of the pending gates.
+	ReadinessGates *readiness.Gates
+}
+
+// OnStartedFunc is a function type for hooks that are run once the server has bound its listeners and started serving.
+// Refer to the documentation of ServerStartInfo for the information provided to the hook. If the function returns an
+// error, the server is shut down and Start returns the error.
+type OnStartedFunc func(ctx context.Context, info ServerStartInfo) error
+
+// OnShutdownFunc is a function type for hooks that are run when the server begins a graceful shutdown, before in-flight
+// requests are drained. The provided context is cancelled once the shutdown timeout elapses.
+type OnShutdownFunc func(ctx context.Context) error
+
+// ServerStartInfo contains information about a server that has started.
+type ServerStartInfo struct {
+	// Address is the address to which the main server's listener is bound. If the configured port was 0, it contains
+	// the port that was assigned.
+	Address net.Addr
+
+	// ManagementAddress is the address to which the management server's listener is bound. If the server does not use
+	// a separate management port, this is the same as Address.
+	ManagementAddress net.Addr
 }
 
 // ConfigurableRouter is a wrouter.Router that provides additional support for configuring things such as health,
@@ -266,6 +314,27 @@ func (s *Server) WithInitFunc(initFn InitFunc) *Server {
 	return s
 }
 
+// WithOnStarted configures the server to run the provided hook once its listeners are bound and it has started serving
+// requests. This is the place to perform work such as announcing the server to service discovery. Hooks are run in the
+// order in which they were registered and are not run once the server has stopped serving. If a hook returns an error,
+// the remaining hooks are skipped, the server is shut down gracefully and Start returns the error. The hooks registered
+// using WithOnShutdown are run as part of this shutdown so that they can undo the work of the on-started hooks that
+// succeeded, so they should tolerate the corresponding on-started hook not having run.
+func (s *Server) WithOnStarted(hook OnStartedFunc) *Server {
+	s.onStartedHooks = append(s.onStartedHooks, hook)
+	return s
+}
+
+// WithOnShutdown configures the server to run the provided hook when Shutdown is called (including shutdowns triggered
+// by SIGTERM or SIGINT and the shutdown that follows a failed on-started hook), before in-flight requests are drained.
+// This is the place to perform work such as deregistering from service discovery or flushing buffers. Hooks are run in
+// the order in which they were registered and their combined execution is bounded by the shutdown timeout: once it
+// elapses, the remaining hooks are abandoned. Hooks are not run when the server is stopped using Close.
+func (s *Server) WithOnShutdown(hook OnShutdownFunc) *Server {
+	s.onShutdownHooks = append(s.onShutdownHooks, hook)
+	return s
+}
+
 // WithInstallConfigType configures the server to use the type of the provided struct as the type for the install
 // configuration. The YAML representation of the install configuration is unmarshaled into a newly created struct that
 // has the same type as the provided struct, so the provided struct should either embed or be compatible with
@@ -409,6 +478,32 @@ func (s *Server) WithMiddleware(middleware wrouter.RequestHandlerMiddleware) *Se
 	return s
 }
 
+// WithDisableRequestLogMiddleware configures the server to not write request log entries for routed requests or for
+// requests rejected because their client certificate failed verification. This is equivalent to
+// WithReplaceDefaultMiddleware(DefaultMiddlewareRequestLog, nil).
+func (s *Server) WithDisableRequestLogMiddleware() *Server {
+	return s.WithReplaceDefaultMiddleware(DefaultMiddlewareRequestLog, nil)
+}
+
+// WithDisableTraceMiddleware configures the server to not create a span for routed requests. This is equivalent to
+// WithReplaceDefaultMiddleware(DefaultMiddlewareTrace, nil).
+func (s *Server) WithDisableTraceMiddleware() *Server {
+	return s.WithReplaceDefaultMiddleware(DefaultMiddlewareTrace, nil)
+}
+
+// WithReplaceDefaultMiddleware configures the server to use the provided middleware in place of the built-in route
+// middleware with the specified name. The replacement runs at the position of the built-in middleware it replaces. If
+// middleware is nil, the built-in middleware is disabled. Start returns an error if name is not the name of a built-in
+// middleware. If the request log middleware is disabled or replaced, requests rejected because their client certificate
+// failed verification are not written to the request log either, as they never reach route middleware.
+func (s *Server) WithReplaceDefaultMiddleware(name DefaultMiddlewareName, middleware wrouter.RouteHandlerMiddleware) *Server {
+	if s.defaultMiddlewareOverrides == nil {
+		s.defaultMiddlewareOverrides = make(map[DefaultMiddlewareName]wrouter.RouteHandlerMiddleware)
+	}
+	s.defaultMiddlewareOverrides[name] = middleware
+	return s
+}
+
 // WithRouterImplProvider configures the server to use the specified routerImplProvider to provide router
 // implementations.
 func (s *Server) WithRouterImplProvider(routerImplProvider func() wrouter.RouterImpl) *Server {
@@ -524,6 +619,7 @@ func (s *Server) WithHealthStatusChangeHandlers(handlers ...status.HealthStatusC
 
 const (
 	defaultMetricEmitFrequency = time.Second * 60
+	defaultShutdownTimeout     = time.Second * 15
 
 	ecvKeyPath        = "var/conf/encrypted-config-value.key"
 	installConfigPath = "var/conf/install.yml"
@@ -576,6 +672,16 @@ func (s *Server) Start() (rErr error) {
 	if err := s.stateManager.Start(); err != nil {
 		return err
 	}
+	s.setShutdownDeadline(time.Time{})
+	// unblock callers waiting for the bound addresses if the server stops before its listeners are bound
+	s.addrs.reset()
+	defer func() {
+		err := rErr
+		if err == nil {
+			err = werror.Error("server stopped before its listeners were bound")
+		}
+		s.addrs.resolve("", "", err)
+	}()
 	// Reset state if server terminated without calling s.Close() or s.Shutdown()
 	defer func() {
 		if s.State() != ServerIdle {
@@ -599,6 +705,20 @@ func (s *Server) Start() (rErr error) {
 		return err
 	}
 
+	if err := validateTimeouts(baseInstallCfg.Server); err != nil {
+		return err
+	}
+	s.shutdownTimeout = baseInstallCfg.Server.ShutdownTimeout
+	if s.shutdownTimeout == 0 {
+		s.shutdownTimeout = defaultShutdownTimeout
+	}
+	if err := validateHTTP2(baseInstallCfg.Server.HTTP2); err != nil {
+		return err
+	}
+	if err := validateDefaultMiddlewareOverrides(s.defaultMiddlewareOverrides); err != nil {
+		return err
+	}
+
 	if s.idsExtractor == nil {
 		s.idsExtractor = extractor.NewDefaultIDsExtractor()
 	}
@@ -631,6 +751,43 @@ func (s *Server) Start() (rErr error) {
 	}
 	internalHealthCheckSources := []healthstatus.HealthCheckSource{configReloadHealthCheckSource}
 
+	// load the server key pair from the configured files and reload it whenever the files change. If the files are not
+	// configured, the error is reported when the TLS configuration is created.
+	var serverCert refreshable.Refreshable
+	if serverCfg := baseInstallCfg.Server; !s.useSelfSignedServerCertificate && serverCfg.CertFile != "" && serverCfg.KeyFile != "" {
+		refreshableServerCert, certReloadHealthCheckSource, err := newRefreshableServerCertificate(ctx, serverCfg.CertFile, serverCfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		serverCert = refreshableServerCert
+		internalHealthCheckSources = append(internalHealthCheckSources, certReloadHealthCheckSource)
+	}
+
+	// client certificates are verified in middleware against a pool of client CAs that is reloaded whenever the CA files
+	// change rather than during the TLS handshake
+	clientAuth, err := s.clientAuthType(baseInstallCfg.Server)
+	if err != nil {
+		return err
+	}
+	if err := validateH2C(baseInstallCfg.Server, clientAuth); err != nil {
+		return err
+	}
+	if serverCfg := baseInstallCfg.Server; serverCfg.H2C && (serverCfg.CertFile != "" || serverCfg.KeyFile != "") {
+		svc1log.FromContext(ctx).Warn("Server certificate and key files are not used by the main server because h2c is enabled",
+			svc1log.SafeParam("certFile", serverCfg.CertFile),
+			svc1log.SafeParam("keyFile", serverCfg.KeyFile))
+	}
+	var clientCAs refreshable.Refreshable
+	if deferredClientAuth, ok := deferredVerificationClientAuthType(clientAuth); ok && len(baseInstallCfg.Server.ClientCAFiles) > 0 {
+		refreshableClientCAs, clientCAReloadHealthCheckSource, err := newRefreshableClientCAs(ctx, baseInstallCfg.Server.ClientCAFiles)
+		if err != nil {
+			return err
+		}
+		clientCAs = refreshableClientCAs
+		clientAuth = deferredClientAuth
+		internalHealthCheckSources = append(internalHealthCheckSources, clientCAReloadHealthCheckSource)
+	}
+
 	// enable TCP logging if the envelope metadata and the TCP receiver are both configured
 	receiverCfg := baseRefreshableRuntimeCfg.CurrentBaseRuntimeConfig().ServiceDiscovery.ClientConfig("sls-log-tcp-json-receiver")
 	envelopeMetadata, err := tcpjson.GetEnvelopeMetadata()
@@ -697,10 +854,10 @@ func (s *Server) Start() (rErr error) {
 	router, mgmtRouter := s.initRouters(baseInstallCfg)
 
 	// add middleware
-	s.addMiddleware(router.RootRouter(), metricsRegistry, s.getApplicationTracingOptions(baseInstallCfg))
+	s.addMiddleware(router.RootRouter(), metricsRegistry, s.getApplicationTracingOptions(baseInstallCfg), baseRefreshableRuntimeCfg, clientCAs)
 	if mgmtRouter != router {
 		// add middleware to management router as well if it is distinct
-		s.addMiddleware(mgmtRouter.RootRouter(), metricsRegistry, s.getManagementTracingOptions(baseInstallCfg))
+		s.addMiddleware(mgmtRouter.RootRouter(), metricsRegistry, s.getManagementTracingOptions(baseInstallCfg), baseRefreshableRuntimeCfg, nil)
 	}
 
 	// handle built-in runtime config changes
@@ -719,6 +876,9 @@ func (s *Server) Start() (rErr error) {
 	// wait for s.Close() or s.Shutdown() to return if called
 	defer s.shutdownFinished.Wait()
 
+	// gates that hold back readiness until warmup tasks started by initFn have completed
+	readinessGates := &readiness.Gates{}
+
 	if s.initFn != nil {
 		traceReporter := wtracing.NewNoopReporter()
 		if s.trcLogger != nil {
@@ -741,13 +901,19 @@ func (s *Server) Start() (rErr error) {
 				InstallConfig:  fullInstallCfg,
 				RuntimeConfig:  refreshableRuntimeCfg,
 				ShutdownServer: s.Shutdown,
+				ReadinessGates: readinessGates,
 			},
 		)
 		if err != nil {
 			return err
 		}
 		if cleanupFn != nil {
-			defer cleanupFn()
+			defer func() {
+				// the main server stops listening as soon as a graceful shutdown begins: ensure that in-flight requests
+				// have drained before the cleanup function is run.
+				s.shutdownFinished.Wait()
+				cleanupFn()
+			}()
 		}
 	}
 
@@ -756,17 +922,19 @@ func (s *Server) Start() (rErr error) {
 
 	// add routes for health, liveness and readiness. Must be done after initFn to ensure that any
 	// health/liveness/readiness configuration updated by initFn is applied.
-	if err := s.addRoutes(mgmtRouter, baseRefreshableRuntimeCfg); err != nil {
+	if err := s.addRoutes(mgmtRouter, baseRefreshableRuntimeCfg, readinessGates); err != nil {
 		return err
 	}
 
 	// only create and start a separate management http server if management port is explicitly specified and differs
 	// from the main server port
+	var mgmtAddr net.Addr
 	if mgmtPort := baseInstallCfg.Server.ManagementPort; mgmtPort != 0 && baseInstallCfg.Server.Port != mgmtPort {
-		mgmtStart, mgmtShutdown, err := s.newMgmtServer(baseInstallCfg.ProductName, baseInstallCfg.Server, mgmtRouter.RootRouter())
+		mgmtListener, mgmtStart, mgmtShutdown, err := s.newMgmtServer(baseInstallCfg.ProductName, baseInstallCfg.Server, serverCert, mgmtRouter.RootRouter())
 		if err != nil {
 			return err
 		}
+		mgmtAddr = mgmtListener.Addr()
 
 		// start management server in its own goroutine
 		go wapp.RunWithRecoveryLogging(ctx, func(ctx context.Context) {
@@ -775,26 +943,57 @@ func (s *Server) Start() (rErr error) {
 			}
 		})
 		defer func() {
-			if err := mgmtShutdown(ctx); err != nil {
+			shutdownCtx, cancel := context.WithDeadline(ctx, s.mgmtShutdownDeadline())
+			defer cancel()
+			if err := mgmtShutdown(shutdownCtx); err != nil {
 				svc1log.FromContext(ctx).Error("management server failed to shutdown", svc1log.Stacktrace(err))
 			}
 		}()
 	}
 
-	httpServer, svrStart, _, err := s.newServer(baseInstallCfg.ProductName, baseInstallCfg.Server, router.RootRouter())
+	httpServer, listener, svrStart, _, err := s.newServer(baseInstallCfg.ProductName, baseInstallCfg.Server, serverCert, clientAuth, router.RootRouter())
 	if err != nil {
 		return err
 	}
+	startInfo := ServerStartInfo{
+		Address:           listener.Addr(),
+		ManagementAddress: listener.Addr(),
+	}
+	if mgmtAddr != nil {
+		startInfo.ManagementAddress = mgmtAddr
+	}
+	s.addrs.resolve(startInfo.Address.String(), startInfo.ManagementAddress.String(), nil)
 
-	s.httpServer = httpServer
 	if s.disableKeepAlives {
-		s.httpServer.SetKeepAlivesEnabled(false)
+		httpServer.SetKeepAlivesEnabled(false)
 	}
+	s.setHTTPServer(httpServer)
 
 	if !s.stateManager.compareAndSwapState(ServerInitializing, ServerRunning) {
+		_ = listener.Close()
 		return werror.ErrorWithContextParams(ctx, "server was shut down before it could start")
 	}
-	return svrStart()
+	if len(s.onStartedHooks) == 0 {
+		return svrStart()
+	}
+
+	svrErr := make(chan error, 1)
+	svrStopped := make(chan struct{})
+	go func() {
+		svrErr <- svrStart()
+		close(svrStopped)
+	}()
+	if err := s.runOnStartedHooks(ctx, startInfo, svrStopped); err != nil {
+		// shut down gracefully so that the on-shutdown hooks can undo the work of the on-started hooks that succeeded
+		shutdownCtx, cancel := context.WithTimeout(ctx, s.shutdownTimeout)
+		if shutdownErr := s.Shutdown(shutdownCtx); shutdownErr != nil {
+			_ = s.Close()
+		}
+		cancel()
+		<-svrErr
+		return err
+	}
+	return <-svrErr
 }
 
 func (s *Server) withLoggers(ctx context.Context) context.Context {
@@ -946,12 +1145,34 @@ func (s *Server) initShutdownSignalHandler(ctx context.Context) {
 	go wapp.RunWithRecoveryLogging(ctx, func(ctx context.Context) {
 		sig := <-shutdownSignal
 		ctx = wparams.ContextWithSafeParam(ctx, "signal", sig.String())
-		if err := s.Shutdown(ctx); err != nil {
-			s.svcLogger.Warn("Failed to gracefully shutdown server.", svc1log.Stacktrace(err))
+		shutdownCtx, cancel := context.WithTimeout(ctx, s.shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			s.svcLogger.Warn("Failed to gracefully shutdown server.", svc1log.Stacktrace(err), svc1log.SafeParam("shutdownTimeout", s.shutdownTimeout.String()))
+			// drain timeout exceeded: terminate any remaining connections
+			if httpServer := s.currentHTTPServer(); httpServer != nil {
+				_ = httpServer.Close()
+			}
 		}
 	})
 }
 
+// Addr returns the address to which the main server's listener is bound, which includes the port that was assigned if
+// the server was configured with port 0. If the server has not yet bound its listener, Addr blocks until it does or
+// until the server fails to start, in which case the error that prevented the server from starting is returned. Once
+// the server has started, Addr returns the address of the most recent start.
+func (s *Server) Addr() (string, error) {
+	addr, _, err := s.addrs.wait()
+	return addr, err
+}
+
+// ManagementAddr returns the address to which the management server's listener is bound. If the server does not use a
+// separate management port, this is the same as the value returned by Addr. Blocks in the same manner as Addr.
+func (s *Server) ManagementAddr() (string, error) {
+	_, mgmtAddr, err := s.addrs.wait()
+	return mgmtAddr, err
+}
+
 // Running returns true if the server is in the "running" state (as opposed to "idle" or "initializing"), false
 // otherwise.
 func (s *Server) Running() bool {
@@ -963,22 +1184,32 @@ func (s *Server) State() ServerState {
 	return s.stateManager.State()
 }
 
+// Shutdown gracefully shuts down the server: it stops accepting new connections and waits for in-flight requests to
+// complete or for the provided context to be done, whichever comes first. Any hooks registered using WithOnShutdown are
+// run before draining begins. Start returns once draining is complete, after which the cleanup function returned by the
+// InitFunc is run.
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.shutdownFinished.Add(1)
 	defer s.shutdownFinished.Done()
 
 	s.svcLogger.Info("Shutting down server")
-	return stopServer(s, func(svr *http.Server) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		s.setShutdownDeadline(deadline)
+	}
+	return stopServer(s, func(svr *trackedHTTPServer) error {
+		s.runOnShutdownHooks(ctx)
 		return svr.Shutdown(ctx)
 	})
 }
 
+// Close immediately closes the server and all of its active connections without waiting for in-flight requests to
+// complete.
 func (s *Server) Close() error {
 	s.shutdownFinished.Add(1)
 	defer s.shutdownFinished.Done()
 
 	s.svcLogger.Info("Closing server")
-	return stopServer(s, func(svr *http.Server) error {
+	return stopServer(s, func(svr *trackedHTTPServer) error {
 		return svr.Close()
 	})
 }
@@ -1001,15 +1232,48 @@ func (s *Server) decryptConfigBytes(cfgBytes []byte) ([]byte, error) {
 	return encryptedconfigvalue.DecryptAllEncryptedValueStringVars(cfgBytes, *ecvKey), nil
 }
 
-func stopServer(s *Server, stopper func(s *http.Server) error) error {
+func stopServer(s *Server, stopper func(s *trackedHTTPServer) error) error {
 	if s.stateManager.State() == ServerIdle {
 		return werror.Error("server is not running")
 	}
 	s.stateManager.setState(ServerIdle)
-	if s.httpServer == nil {
+	httpServer := s.currentHTTPServer()
+	if httpServer == nil {
 		return nil
 	}
-	return stopper(s.httpServer)
+	return stopper(httpServer)
+}
+
+func (s *Server) currentHTTPServer() *trackedHTTPServer {
+	s.httpServerMutex.Lock()
+	defer s.httpServerMutex.Unlock()
+	return s.httpServer
+}
+
+func (s *Server) setHTTPServer(httpServer *trackedHTTPServer) {
+	s.httpServerMutex.Lock()
+	defer s.httpServerMutex.Unlock()
+	s.httpServer = httpServer
+}
+
+func (s *Server) setShutdownDeadline(deadline time.Time) {
+	s.httpServerMutex.Lock()
+	defer s.httpServerMutex.Unlock()
+	s.shutdownDeadline = deadline
+}
+
+// mgmtShutdownDeadline returns the deadline for draining the management server, which is shutdownTimeout from now or
+// the deadline of the main server's graceful shutdown, whichever is earlier. The management server is drained after
+// the main server stops serving, so sharing the deadline ensures that a shutdown triggered by a signal completes within
+// shutdownTimeout rather than allowing the management server another shutdownTimeout of its own.
+func (s *Server) mgmtShutdownDeadline() time.Time {
+	deadline := time.Now().Add(s.shutdownTimeout)
+	s.httpServerMutex.Lock()
+	defer s.httpServerMutex.Unlock()
+	if !s.shutdownDeadline.IsZero() && s.shutdownDeadline.Before(deadline) {
+		return s.shutdownDeadline
+	}
+	return deadline
 }
 
 func (s *Server) getApplicationTracingOptions(install config.Install) []wtracing.TracerOption {
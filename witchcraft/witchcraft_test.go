@@ -17,6 +17,7 @@ package witchcraft_test
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -514,6 +515,65 @@ func testStop(t *testing.T, stop func(*witchcraft.Server, context.Context) error
 	}
 }
 
+func TestServer_Addr(t *testing.T) {
+	t.Run("returns assigned port", func(t *testing.T) {
+		server, cleanup := newServer("127.0.0.1", 0)
+		defer cleanup()
+		errc := make(chan error, 1)
+		go func() {
+			errc <- server.Start()
+		}()
+
+		addr, err := server.Addr()
+		require.NoError(t, err)
+		host, portStr, err := net.SplitHostPort(addr)
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1", host)
+		assert.NotEqual(t, "0", portStr)
+
+		mgmtAddr, err := server.ManagementAddr()
+		require.NoError(t, err)
+		assert.Equal(t, addr, mgmtAddr)
+
+		// self-signed certificate includes the bound IP
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		peerCerts := conn.ConnectionState().PeerCertificates
+		require.NoError(t, conn.Close())
+		require.Len(t, peerCerts, 1)
+		require.Len(t, peerCerts[0].IPAddresses, 1)
+		assert.True(t, peerCerts[0].IPAddresses[0].Equal(net.ParseIP("127.0.0.1")))
+
+		require.NoError(t, server.Close())
+		assert.NoError(t, <-errc)
+	})
+
+	t.Run("returns error if server fails to start", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer func() {
+			_ = ln.Close()
+		}()
+
+		server, cleanup := newServer("127.0.0.1", ln.Addr().(*net.TCPAddr).Port)
+		defer cleanup()
+		addrc := make(chan error, 1)
+		go func() {
+			_, err := server.Addr()
+			addrc <- err
+		}()
+		startErr := server.Start()
+		require.Error(t, startErr)
+
+		select {
+		case err := <-addrc:
+			assert.Error(t, err)
+		case <-time.After(2 * time.Second):
+			assert.Fail(t, "timed out waiting for Addr() to return")
+		}
+	})
+}
+
 func newServer(host string, port int) (*witchcraft.Server, func()) {
 	server := witchcraft.NewServer().
 		WithSelfSignedCertificate().